@@ -0,0 +1,1613 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafov/m3u8"
+)
+
+// AnalyzeConfig holds the inputs and tuning knobs of an analysis run
+type AnalyzeConfig struct {
+	// MezzanineFile is the local reference, left empty when RelativeToTopVariant or DumpOnly is set
+	MezzanineFile string
+	ManifestURL   string
+	DataFile      string
+	// Ladder is a JSON list of rendition files and bandwidths scored instead of a manifest's variants
+	Ladder string
+	// VideoStreamIndex picks which of the mezzanine's video streams is the reference
+	VideoStreamIndex int
+	// BandwidthBuckets and BandwidthBucketKbps describe the data file's bandwidth distribution,
+	// 0 uses the default 100 buckets of 100kbps
+	BandwidthBuckets    int
+	BandwidthBucketKbps int
+
+	Model string
+	// PhoneModel scores with the model's phone transform
+	PhoneModel bool
+	// MinResolution overrides the model's smallest scored width or height when set
+	MinResolution uint64
+	// ModelSHA256 is the checksum the model file must have, when set
+	ModelSHA256 string
+	// Estimator is vmaf for vmafossexec or libvmaf for libvmaf's vmaf tool on the CPU, or
+	// vmaf-cuda for libvmaf on the GPU
+	Estimator  string
+	Threads    uint64
+	Subsample  uint64
+	ZeroPolicy ZeroPolicy
+	// PoolMethod defaults to the harmonic mean
+	PoolMethod PoolMethod
+	Limits     *ProcessLimits
+	// Metric averages and gates on PSNR or SSIM instead of VMAF, skipping the model
+	Metric Metric
+	// ExtraMetrics are computed alongside VMAF, all of them when nil
+	ExtraMetrics *ExtraMetrics
+
+	// Decoder replaces the ffmpeg decoder, which is otherwise set up from Limits, PipeSize,
+	// the binary paths and the netrc credentials
+	Decoder Decoder
+	// FFmpegPath and FFprobePath override the binaries found on PATH
+	FFmpegPath  string
+	FFprobePath string
+
+	// PipeSize resizes the decode FIFO buffers on linux, 0 keeps the system default
+	PipeSize int
+
+	// HTTPRetries is how many times transient manifest and segment fetch failures are retried,
+	// HTTPTimeout bounds each request with 0 for no limit
+	HTTPRetries int
+	HTTPTimeout time.Duration
+
+	// Netrc is an explicit netrc file, otherwise $NETRC or ~/.netrc is used when present
+	Netrc string
+
+	// Headers and Cookies are sent with every manifest and segment request, an Authorization
+	// header takes precedence over netrc
+	Headers http.Header
+	Cookies []string
+
+	// CompareResolution scores each variant once at WxH, 'native' or 'mezzanine' instead of the grid
+	CompareResolution    string
+	RelativeToTopVariant bool
+	VariantFilter        *regexp.Regexp
+	DASHSegments         int
+	DumpDir              string
+	// DumpConcurrency is how many variants are dumped at once, 0 or 1 dumps them one at a time
+	DumpConcurrency int
+	DumpOnly        bool
+
+	// WorkDir holds the decode FIFOs and VMAF logs, defaulting to /tmp
+	WorkDir string
+
+	// MezzanineCacheDir keeps the mezzanine decoded at each resolution under this directory for
+	// reuse across variants, instead of decoding it straight into the FIFO every time
+	MezzanineCacheDir string
+
+	// ReferenceYUV maps WxH resolutions onto the mezzanine already decoded to raw frames at them,
+	// which are fed to VMAF instead of decoding the mezzanine
+	ReferenceYUV map[string]string
+
+	// FrameTolerance is how many frames a variant may differ from the mezzanine by, both are
+	// truncated to the shorter length when they do
+	FrameTolerance uint64
+
+	// SkipIdentical hashes the scored frames of variants at their native resolution and gives
+	// those identical to the reference a perfect score without running VMAF
+	SkipIdentical bool
+
+	// TrimFramesStart and TrimFramesEnd drop boundary frames from both inputs before scoring
+	TrimFramesStart uint64
+	TrimFramesEnd   uint64
+
+	// VariantAttributes cross-checks each variant's declared attributes against its probed stream
+	VariantAttributes bool
+
+	// AllowDurationMismatch only warns when variants and the mezzanine differ in length
+	AllowDurationMismatch bool
+	// AllowFPSMismatch only warns when variants and the mezzanine differ in frame rate
+	AllowFPSMismatch bool
+
+	// DataSums handles distributions that don't sum to 1, the zero value warns beyond 0.01
+	DataSums DataSumPolicy
+
+	// WeightBy averages over viewers (population, the default) or watch time (viewtime)
+	WeightBy string
+
+	// PercentFormat is how shares of users are logged, PercentFormatPercent when empty
+	PercentFormat string
+
+	// KeepIntermediates leaves the dumped variants and decode FIFOs behind for debugging
+	KeepIntermediates bool
+
+	// DryRun validates the inputs and prints what would be scored without running VMAF
+	DryRun bool
+
+	// RunID names the directory under the work directory's logs the run's VMAF logs are written to,
+	// a timestamp and the process ID when empty
+	RunID string
+
+	// PipelineDepth is how many variant and resolution pairs are decoded and scored at once, each
+	// through its own FIFOs. 0 and 1 score them one after another.
+	PipelineDepth int
+
+	// PixelFormat is the raw format inputs are decoded to and VMAF reads, 8-bit yuv420p when unset
+	PixelFormat PixelFormat
+	// Scaler is the algorithm both inputs are resized with, DefaultScaler when empty
+	Scaler string
+
+	// MaxThreads caps the VMAF threads in use across every pair scored at once, each run taking
+	// Threads of it. 0 leaves it to PipelineDepth.
+	MaxThreads int
+
+	// PerFrameCSV writes each run's per-frame scores to a CSV next to its JSON log
+	PerFrameCSV bool
+
+	// SegmentDuration scores long content in windows of about this long, 0 scoring it all at once
+	SegmentDuration time.Duration
+	// FrameSelect scores only some of the frames when set, the same frame numbers of both inputs
+	FrameSelect *FrameSelection
+
+	DetectLoops   bool
+	SegmentScores bool
+	Pareto        bool
+
+	// ReferenceLadder is an earlier run's JSON report each rendition is compared against
+	ReferenceLadder string
+
+	// OnProgress is called before each variant and resolution pair is scored, and once more when
+	// the sweep completes
+	OnProgress func(Progress)
+
+	ResultPrecision int
+	MinVMAF         float64
+	AbortOnFirstLow bool
+
+	// OnlyVariant and OnlyResolution restrict scoring to one variant, by its index in bandwidth
+	// order, and to one WxH resolution, for debugging a single rendition. The report is marked partial.
+	OnlyVariant    *int
+	OnlyResolution string
+
+	// Strict fails the run on signs the mezzanine and variants were swapped, which otherwise
+	// only warn
+	Strict bool
+
+	// LowVMAFThreshold aborts the run on a score so low the inputs are most likely misconfigured,
+	// unlike the MinVMAF quality gate. It's only checked when scoring VMAF, 0 disables it.
+	LowVMAFThreshold float64
+}
+
+// LowScoreError is returned when scores fall below the MinVMAF quality gate
+type LowScoreError struct {
+	MinVMAF float64
+	Low     []string
+	Aborted bool
+}
+
+func (e *LowScoreError) Error() string {
+	minVMAF := strconv.FormatFloat(e.MinVMAF, 'f', -1, 64)
+	if e.Aborted {
+		return fmt.Sprintf("Aborting, %s which is below --min-vmaf %s", e.Low[0], minVMAF)
+	}
+	return fmt.Sprintf("%d scores are below --min-vmaf %s:\n  %s", len(e.Low), minVMAF, strings.Join(e.Low, "\n  "))
+}
+
+// StageError is a failure of one stage of the run, StageProbe or StageVMAF, letting callers tell
+// bad inputs apart from failed scoring
+type StageError struct {
+	Stage string
+	Err   error
+}
+
+func (e *StageError) Error() string {
+	return e.Err.Error()
+}
+
+// Analyze dumps the manifest's variants, scores them against the reference and pools the scores
+// over the viewer population. When scores fall below the quality gate the report is returned
+// alongside a *LowScoreError, holding only the scores so far when the gate aborted the sweep.
+func Analyze(ctx context.Context, cfg AnalyzeConfig) (*RunReport, error) {
+	relative := cfg.RelativeToTopVariant
+	mezzanineFile := cfg.MezzanineFile
+	if cfg.ManifestURL == "" && cfg.Ladder == "" {
+		return nil, fmt.Errorf("A manifest URL or ladder file is required")
+	}
+	if cfg.ManifestURL != "" && cfg.Ladder != "" {
+		return nil, fmt.Errorf("A manifest URL and a ladder file can't both be analyzed")
+	}
+	var manifestURL string
+	var err error
+	if cfg.ManifestURL != "" {
+		if manifestURL, err = manifestLocation(cfg.ManifestURL); err != nil {
+			return nil, fmt.Errorf("Invalid manifest path %q: %v", cfg.ManifestURL, err)
+		}
+	}
+	_, localManifest := localPath(manifestURL)
+	// a ladder's renditions are already on disk, so aren't fetched or dumped
+	ladder := cfg.Ladder != ""
+	localManifest = localManifest || ladder
+	if ladder && cfg.DumpOnly {
+		return nil, fmt.Errorf("A ladder's renditions are already on disk, there is nothing to dump")
+	}
+	if cfg.SegmentDuration > 0 && (cfg.SegmentScores || cfg.PerFrameCSV || cfg.DetectLoops) {
+		return nil, fmt.Errorf("Windows are scored one at a time, so --segment-duration can't be combined with --segment-report, --per-frame-csv or --detect-loops")
+	}
+	if cfg.FrameSelect != nil {
+		if cfg.Decoder != nil {
+			return nil, fmt.Errorf("--frame-select is applied by ffmpeg's select filter, so needs the ffmpeg decoder")
+		}
+		if cfg.MezzanineCacheDir != "" || len(cfg.ReferenceYUV) > 0 || cfg.SegmentDuration > 0 {
+			return nil, fmt.Errorf("--frame-select can't be combined with --mezz-cache-dir, --reference-yuv or --segment-duration, which don't decode with its filter")
+		}
+		if cfg.SegmentScores || cfg.PerFrameCSV || cfg.DetectLoops {
+			return nil, fmt.Errorf("VMAF logs number the selected frames from 0, so --frame-select can't be combined with --segment-report, --per-frame-csv or --detect-loops")
+		}
+	}
+	if ladder && cfg.SegmentScores {
+		return nil, fmt.Errorf("Segment scores need the variant playlists, which a ladder file doesn't have")
+	}
+	if mezzanineFile == "" && !relative && !cfg.DumpOnly {
+		return nil, fmt.Errorf("A mezzanine file is required unless scoring relative to the top variant")
+	}
+	scoreLabel := "VMAF"
+	if cfg.Metric != "" {
+		scoreLabel = strings.ToUpper(string(cfg.Metric))
+	}
+	if relative {
+		scoreLabel = "relative " + scoreLabel + " (vs top variant)"
+	}
+	// dumpContainers is the container each variant is dumped to, TS unless its segments are fMP4
+	var dumpContainers []string
+	// ladderFiles are the renditions of a ladder file, used where the dumps otherwise are
+	var ladderFiles []string
+	dumpPath := func(variant int) string {
+		if ladder {
+			return ladderFiles[variant]
+		}
+		return variantDumpPath(cfg.DumpDir, variant, dumpContainers[variant])
+	}
+
+	// ffmpeg decoder, unless the caller brought their own
+	decoder := cfg.Decoder
+	var ffmpeg *FFMegDecoder
+	if decoder == nil {
+		ffmpeg = NewFFmpegDecoder()
+		if cfg.FFmpegPath != "" {
+			ffmpeg.FFmpegPath = cfg.FFmpegPath
+		}
+		if cfg.FFprobePath != "" {
+			ffmpeg.FFprobePath = cfg.FFprobePath
+		}
+		ffmpeg.Limits = cfg.Limits
+		ffmpeg.PipeSize = cfg.PipeSize
+		ffmpeg.HTTPRetries = cfg.HTTPRetries
+		ffmpeg.HTTPTimeout = cfg.HTTPTimeout
+		ffmpeg.MezzanineFile = mezzanineFile
+		ffmpeg.VideoStreamIndex = cfg.VideoStreamIndex
+		decoder = ffmpeg
+	}
+	pixelFormat := cfg.PixelFormat
+	if pixelFormat.Name == "" {
+		pixelFormat = DefaultPixelFormat
+	}
+	scaler := cfg.Scaler
+	if scaler == "" {
+		scaler = DefaultScaler
+	}
+	if ffmpeg != nil {
+		ffmpeg.PixelFormat = pixelFormat.Name
+		ffmpeg.Scaler = scaler
+	}
+	// the decode FIFOs and VMAF logs live under the work directory
+	workDir := cfg.WorkDir
+	if workDir == "" {
+		workDir = defaultWorkDir
+	}
+	mezzanineDecodePath := filepath.Join(workDir, mezzanineDecodeName)
+	distortedDecodePath := filepath.Join(workDir, distortedDecodeName)
+	// each run logs to its own directory, so runs sharing the work directory keep their logs apart
+	runID := cfg.RunID
+	if runID == "" {
+		runID = fmt.Sprintf("%s-%d", time.Now().UTC().Format("20060102T150405Z"), os.Getpid())
+	}
+	logsPath := filepath.Join(workDir, logsDir, runID)
+
+	// dumps and FIFOs are removed however the run ends, the dumps are the point of a dump-only run
+	var intermediates []string
+	if !cfg.KeepIntermediates {
+		intermediates = append(intermediates, mezzanineDecodePath, distortedDecodePath)
+		defer func() {
+			removeIntermediates(intermediates, cfg.MezzanineFile)
+		}()
+	}
+
+	cpuVMAF := NewVMAFEstimator(mezzanineDecodePath, distortedDecodePath, cfg.Model, logsPath, cfg.Threads, cfg.Subsample)
+	cpuVMAF.ZeroPolicy = cfg.ZeroPolicy
+	if cfg.PoolMethod != "" {
+		cpuVMAF.PoolMethod = cfg.PoolMethod
+	}
+	cpuVMAF.Limits = cfg.Limits
+	cpuVMAF.PhoneModel = cfg.PhoneModel
+	cpuVMAF.Metric = cfg.Metric
+	cpuVMAF.PixelFormat = pixelFormat
+	if cfg.ExtraMetrics != nil {
+		cpuVMAF.Extras = *cfg.ExtraMetrics
+	}
+	// the metrics the estimator logs besides VMAF, for the per-frame CSV
+	logged := cpuVMAF.Extras
+	if cfg.MinResolution > 0 {
+		// copied, the profiles are shared
+		overridden := *cpuVMAF.Profile
+		overridden.MinResolution = cfg.MinResolution
+		cpuVMAF.Profile = &overridden
+		slog.Info("Overriding the model's minimum resolution", "min_resolution", cfg.MinResolution, "profile", overridden.Name, "model_min_resolution", LookupModelProfile(cfg.Model).MinResolution)
+	}
+	profile := cpuVMAF.Profile
+	var vmaf Estimator
+	switch cfg.Estimator {
+	case "", EstimatorVMAF:
+		if !cpuVMAF.scoresVMAF() {
+			return nil, fmt.Errorf("vmafossexec always loads the model, score %s with the %s estimator", cfg.Metric, EstimatorLibVMAF)
+		}
+		if !cfg.DumpOnly {
+			if err := cpuVMAF.Preflight(ctx); err != nil {
+				return nil, err
+			}
+		}
+		vmaf = cpuVMAF
+	case EstimatorLibVMAF:
+		libvmaf := NewLibVMAFEstimator(cpuVMAF)
+		if !cpuVMAF.scoresVMAF() {
+			logged = ExtraMetrics{PSNR: cfg.Metric == MetricPSNR, SSIM: cfg.Metric == MetricSSIM}
+		}
+		if err := libvmaf.Preflight(ctx); err != nil {
+			return nil, err
+		}
+		vmaf = libvmaf
+	case EstimatorVMAFCUDA:
+		cuda := NewCUDAVMAFEstimator(cpuVMAF, cfg.FFmpegPath)
+		logged = ExtraMetrics{}
+		if err := cuda.Preflight(ctx); err != nil {
+			return nil, err
+		}
+		vmaf = cuda
+	default:
+		return nil, fmt.Errorf("Unknown estimator %q, must be %s, %s or %s", cfg.Estimator, EstimatorVMAF, EstimatorLibVMAF, EstimatorVMAFCUDA)
+	}
+	slog.Info("Using harmonic mean zero policy", "policy", cfg.ZeroPolicy)
+
+	// each pair scored at once decodes into its own FIFOs, read by its own estimator
+	depth := cfg.PipelineDepth
+	if depth < 1 {
+		depth = 1
+	}
+	slots := []*vmafSlot{{mezzanineDecodePath, distortedDecodePath, vmaf}}
+	for k := 1; k < depth; k++ {
+		slotVMAF := *cpuVMAF
+		slotVMAF.ReferencesDecodePath = slotPath(mezzanineDecodePath, k)
+		slotVMAF.DistortedDecodePath = slotPath(distortedDecodePath, k)
+		slot := &vmafSlot{slotVMAF.ReferencesDecodePath, slotVMAF.DistortedDecodePath, &slotVMAF}
+		switch cfg.Estimator {
+		case EstimatorLibVMAF:
+			slot.vmaf = NewLibVMAFEstimator(&slotVMAF)
+		case EstimatorVMAFCUDA:
+			slot.vmaf = NewCUDAVMAFEstimator(&slotVMAF, cfg.FFmpegPath)
+		}
+		slots = append(slots, slot)
+	}
+	budget := newThreadBudget(cfg.MaxThreads)
+	var fifos []string
+	for _, slot := range slots {
+		fifos = append(fifos, slot.mezzaninePath, slot.distortedPath)
+	}
+	if !cfg.KeepIntermediates {
+		intermediates = append(intermediates, fifos[2:]...)
+	}
+
+	// pin down exactly which model scores the run
+	modelHash, err := modelSHA256(cfg.Model)
+	if err != nil {
+		if cfg.ModelSHA256 != "" {
+			return nil, fmt.Errorf("Failed to hash model %q: %v", cfg.Model, err)
+		}
+		slog.Warn("Unable to hash model", "model", cfg.Model, "error", err)
+	} else {
+		slog.Info("Model checksum", "model", cfg.Model, "sha256", modelHash)
+		if cfg.ModelSHA256 != "" && !strings.EqualFold(modelHash, cfg.ModelSHA256) {
+			return nil, fmt.Errorf("Model %q has SHA-256 %s, but %s was expected", cfg.Model, modelHash, cfg.ModelSHA256)
+		}
+	}
+
+	// load the known-good ladder up front rather than failing after the sweep
+	var referenceLadder *RunReport
+	if cfg.ReferenceLadder != "" {
+		if referenceLadder, err = loadRunReport(cfg.ReferenceLadder); err != nil {
+			return nil, fmt.Errorf("Failed to load reference ladder %q: %v", cfg.ReferenceLadder, err)
+		}
+	}
+
+	// progress is counted in scored variant and resolution pairs
+	asset := filepath.Base(mezzanineFile)
+	if relative {
+		asset = manifestURL
+		if ladder {
+			asset = cfg.Ladder
+		}
+	}
+	progress := NewProgressReporter(asset, cfg.OnProgress)
+
+	// Probe the input file
+	probeStart := time.Now()
+	var mezzanineInfo *FFProbeOutput
+	var videoStream *FFProbeStream
+	if mezzanineFile != "" && !relative {
+		slog.Info("Probing mezzanine file", "file", mezzanineFile)
+		if mezzanineInfo, err = decoder.ProbeStreams(ctx, mezzanineFile); err != nil {
+			return nil, &StageError{StageProbe, fmt.Errorf("Failed to probe file: %v", err)}
+		}
+		// the probe only selects the requested video stream
+		if len(mezzanineInfo.Streams) == 0 {
+			return nil, &StageError{StageProbe, fmt.Errorf("Input file has no video stream %d", cfg.VideoStreamIndex)}
+		}
+		videoStream = mezzanineInfo.Streams[0]
+		if videoStream.Width == 0 || videoStream.Height == 0 {
+			return nil, &StageError{StageProbe, fmt.Errorf("Input file must have a valid width and height, but has %dx%d", videoStream.Width, videoStream.Height)}
+		}
+		slog.Info("Probed mezzanine", resolution(videoStream.Width, videoStream.Height), "pix_fmt", videoStream.PixFmt)
+		if depth := sourceBitDepth(videoStream.PixFmt); depth > pixelFormat.BitDepth {
+			slog.Warn("Mezzanine has a higher bit depth than it's decoded to, losing quality differences", "pix_fmt", videoStream.PixFmt, "decode_pix_fmt", pixelFormat.Name)
+		}
+		for size, path := range cfg.ReferenceYUV {
+			width, height, err := parseResolution(size)
+			if err != nil {
+				return nil, err
+			}
+			if err := validateReferenceYUV(path, width, height, mezzanineInfo.FrameCount(), pixelFormat); err != nil {
+				return nil, err
+			}
+			slog.Info("Using pre-decoded reference", resolution(width, height), "path", path)
+		}
+		if err := vmaf.ValidateSource(videoStream.Width, videoStream.Height); err != nil {
+			return nil, fmt.Errorf("Invalid model for mezzanine: %v", err)
+		}
+	}
+	progress.Time(StageProbe, probeStart)
+
+	windowed := isDASHManifest(manifestURL) && cfg.DASHSegments > 0
+	if isDASHManifest(manifestURL) && cfg.SegmentScores {
+		return nil, fmt.Errorf("Segment scores are only supported for HLS manifests")
+	}
+
+	// origin credentials are looked up per host, for the manifest fetch, the playlist and segment
+	// fetches and each of ffmpeg's dumps
+	requestHeaders := cfg.Headers.Clone()
+	if requestHeaders == nil {
+		requestHeaders = http.Header{}
+	}
+	parsedManifestURL, err := url.Parse(manifestURL)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid manifest URL %q: %v", manifestURL, err)
+	}
+	var netrc *NetrcAuth
+	if path := netrcPath(cfg.Netrc); path != "" && !localManifest && requestHeaders.Get("Authorization") == "" {
+		netrc = &NetrcAuth{Path: path}
+		if _, err := netrc.Authorization(parsedManifestURL.Hostname()); err != nil {
+			if cfg.Netrc != "" || !os.IsNotExist(err) {
+				return nil, fmt.Errorf("Failed to read netrc file %q: %v", path, err)
+			}
+			netrc = nil
+		}
+	}
+	if ffmpeg != nil {
+		ffmpeg.Headers = requestHeaders
+		ffmpeg.Netrc = netrc
+		ffmpeg.Cookies = cfg.Cookies
+	}
+	fetchHeaders := requestHeaders
+	if len(cfg.Cookies) > 0 {
+		fetchHeaders = requestHeaders.Clone()
+		fetchHeaders.Set("Cookie", cookieHeader(cfg.Cookies))
+	}
+	fetcher := &HTTPFetcher{
+		Client:  &http.Client{Timeout: cfg.HTTPTimeout},
+		Headers: fetchHeaders,
+		Netrc:   netrc,
+		Retries: cfg.HTTPRetries,
+	}
+
+	var sortedVariants []*m3u8.Variant
+	if ladder {
+		slog.Info("Loading ladder file", "ladder", cfg.Ladder)
+		if sortedVariants, err = loadLadderFile(cfg.Ladder); err != nil {
+			return nil, fmt.Errorf("Failed to load ladder %q: %v", cfg.Ladder, err)
+		}
+	} else if isDASHManifest(manifestURL) {
+		// download a window of each representation's SegmentTemplate segments
+		slog.Info("Retrieving DASH manifest", "uri", manifestURL)
+		if err := os.MkdirAll(cfg.DumpDir, 0755); err != nil {
+			return nil, fmt.Errorf("Failed to create dump directory %q: %v", cfg.DumpDir, err)
+		}
+		if sortedVariants, err = loadDASHVariants(ctx, manifestURL, fetcher, cfg.DASHSegments, cfg.DumpDir); err != nil {
+			return nil, fmt.Errorf("Failed to load DASH manifest: %v", err)
+		}
+		if !cfg.KeepIntermediates && !cfg.DumpOnly {
+			for _, variant := range sortedVariants {
+				intermediates = append(intermediates, variant.URI)
+			}
+		}
+	} else {
+		// Load the master manfest
+		slog.Info("Retrieving master manifest", "uri", manifestURL)
+		manifest, manifestType, err := fetchPlaylist(ctx, manifestURL, fetcher)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to load master manifest: %v", err)
+		}
+		var masterPlaylist *m3u8.MasterPlaylist
+		switch manifestType {
+		case m3u8.MASTER:
+			masterPlaylist = manifest.(*m3u8.MasterPlaylist)
+		default:
+			return nil, fmt.Errorf("Invalid manifest format, must be a master manifest")
+		}
+		sortedVariants = masterPlaylist.Variants
+		if err := resolveVariantURIs(manifestURL, sortedVariants); err != nil {
+			return nil, err
+		}
+	}
+
+	// get variants
+	sort.Sort(ByBandwidth(sortedVariants))
+	slog.Info("Loaded variants", "variants", len(sortedVariants))
+
+	// only keep the variants the user asked for
+	if cfg.VariantFilter != nil {
+		var matched []*m3u8.Variant
+		for _, variant := range sortedVariants {
+			if variantMatches(cfg.VariantFilter, variant) {
+				slog.Info("Variant matches filter", "uri", variant.URI, "bandwidth", variant.Bandwidth, "filter", cfg.VariantFilter.String())
+				matched = append(matched, variant)
+			}
+		}
+		if len(matched) == 0 {
+			return nil, fmt.Errorf("No variants match %q", cfg.VariantFilter)
+		}
+		sortedVariants = matched
+		slog.Info("Analyzing matching variants", "variants", len(sortedVariants))
+	}
+
+	// fMP4 segments can't be copied into a TS container, so dump them to MP4 instead
+	dumpContainers = make([]string, len(sortedVariants))
+	ladderFiles = make([]string, len(sortedVariants))
+	for i, variant := range sortedVariants {
+		dumpContainers[i] = dumpContainerTS
+		if ladder {
+			ladderFiles[i] = variant.URI
+			continue
+		}
+		if isDASHManifest(manifestURL) {
+			continue
+		}
+		playlistURL, err := resolveURI(manifestURL, variant.URI)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid variant URI %q: %v", variant.URI, err)
+		}
+		playlist, playlistType, err := fetchPlaylist(ctx, playlistURL, fetcher)
+		if err != nil {
+			slog.Warn("Unable to load variant playlist to detect its segment container, assuming TS", "variant", i, "error", err)
+			continue
+		}
+		if playlistType == m3u8.MEDIA {
+			dumpContainers[i] = segmentContainer(playlist.(*m3u8.MediaPlaylist))
+		}
+		slog.Debug("Variant segment container", "variant", i, "container", dumpContainers[i])
+	}
+
+	// onlyPair is whether a pair is in the subset picked by OnlyVariant and OnlyResolution
+	var onlyWidth, onlyHeight uint64
+	if cfg.OnlyResolution != "" {
+		if onlyWidth, onlyHeight, err = parseResolution(cfg.OnlyResolution); err != nil {
+			return nil, fmt.Errorf("Invalid --only-resolution: %v", err)
+		}
+	}
+	if cfg.OnlyVariant != nil && (*cfg.OnlyVariant < 0 || *cfg.OnlyVariant >= len(sortedVariants)) {
+		return nil, fmt.Errorf("--only-variant %d doesn't exist, the ladder has variants 0 to %d", *cfg.OnlyVariant, len(sortedVariants)-1)
+	}
+	onlyPair := func(variant int, width, height uint64) bool {
+		if cfg.OnlyVariant != nil && variant != *cfg.OnlyVariant {
+			return false
+		}
+		return cfg.OnlyResolution == "" || (width == onlyWidth && height == onlyHeight)
+	}
+
+	// a reference that's no better than the variants was most likely passed in the wrong place
+	suspectSwapped := func(reason string, args ...interface{}) error {
+		if cfg.Strict {
+			return fmt.Errorf("%s, the mezzanine and variants may be swapped", reason)
+		}
+		slog.Warn(reason+", the mezzanine and variants may be swapped", args...)
+		return nil
+	}
+	if videoStream != nil && !relative {
+		if mezzanineBitrate := uint64(videoStream.BitRate); mezzanineBitrate > 0 {
+			for i, variant := range sortedVariants {
+				if uint64(variant.Bandwidth) <= mezzanineBitrate {
+					continue
+				}
+				reason := fmt.Sprintf("Variant %d at %d bps has a higher bitrate than the mezzanine's %d bps", i, variant.Bandwidth, mezzanineBitrate)
+				if err := suspectSwapped(reason, "variant", i, "bandwidth", variant.Bandwidth, "mezzanine_bitrate", mezzanineBitrate); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	// parse variants and validate
+	if err := os.MkdirAll(cfg.DumpDir, 0755); err != nil {
+		return nil, fmt.Errorf("Failed to create dump directory %q: %v", cfg.DumpDir, err)
+	}
+	variantInfo := make([]*FFProbeOutput, len(sortedVariants))
+	if !cfg.KeepIntermediates && !cfg.DumpOnly && !ladder {
+		for i := range sortedVariants {
+			intermediates = append(intermediates, dumpPath(i))
+		}
+	}
+	dumpStart := time.Now()
+	// the renditions of a ladder file are probed where they are, leaving nothing to dump
+	if ladder {
+		for i := range sortedVariants {
+			slog.Info("Probing ladder rendition", "variant", i, "file", ladderFiles[i], "bandwidth", sortedVariants[i].Bandwidth)
+			if variantInfo[i], err = decoder.ProbeStreams(ctx, ladderFiles[i]); err != nil {
+				return nil, fmt.Errorf("Failed to probe ladder rendition %q: %v", ladderFiles[i], err)
+			}
+			if len(variantInfo[i].Streams) != 1 {
+				return nil, fmt.Errorf("Ladder rendition %q has no video track", ladderFiles[i])
+			}
+		}
+	}
+	if relative {
+		if len(sortedVariants) < 2 {
+			return nil, fmt.Errorf("Relative scoring needs at least 2 variants, but the manifest has %d", len(sortedVariants))
+		}
+
+		top := len(sortedVariants) - 1
+		mezzanineFile = dumpPath(top)
+		if variantInfo[top] == nil {
+			slog.Info("Dumping top variant for use as the reference", "variant", top)
+			if variantInfo[top], err = decoder.DumpStream(ctx, sortedVariants[top].URI, mezzanineFile); err != nil {
+				return nil, fmt.Errorf("Failed to dump stream: %v", err)
+			}
+		}
+		if len(variantInfo[top].Streams) != 1 {
+			return nil, fmt.Errorf("Invalid variant stream has no video track")
+		}
+		mezzanineInfo = variantInfo[top]
+		videoStream = mezzanineInfo.Streams[0]
+		slog.Info("Scores are relative to the top variant", resolution(videoStream.Width, videoStream.Height))
+		if err := vmaf.ValidateSource(videoStream.Width, videoStream.Height); err != nil {
+			return nil, fmt.Errorf("Invalid model for reference: %v", err)
+		}
+	}
+	if err := dumpVariants(ctx, decoder, sortedVariants, variantInfo, dumpPath, cfg.DumpConcurrency); err != nil {
+		return nil, err
+	}
+	progress.Time(StageDump, dumpStart)
+
+	// a mezzanine and ladder of different lengths can't be aligned, so catch it before the sweep.
+	// windows of DASH segments are shorter than the mezzanine on purpose.
+	if mezzanineInfo != nil && !windowed {
+		mezzanineDuration := mezzanineInfo.Duration()
+		var mismatched []string
+		for i := range sortedVariants {
+			duration := variantInfo[i].Duration()
+			delta := duration - mezzanineDuration
+			slog.Info("Variant duration", "variant", i, "duration", duration, "mezzanine_duration", mezzanineDuration, "delta", delta)
+			if math.Abs(delta) > maxDurationDelta {
+				mismatched = append(mismatched, fmt.Sprintf("variant %d is %.3fs against the mezzanine's %.3fs (delta %+.3fs)", i, duration, mezzanineDuration, delta))
+			}
+		}
+		if len(mismatched) > 0 {
+			if !cfg.AllowDurationMismatch {
+				return nil, fmt.Errorf("%d variants differ in duration from the mezzanine by more than %.1fs:\n  %s", len(mismatched), maxDurationDelta, strings.Join(mismatched, "\n  "))
+			}
+			listing := strings.Join(mismatched, "; ")
+			slog.Warn("Continuing despite variants differing in duration from the mezzanine", "variants", len(mismatched), "mismatches", listing)
+		}
+	}
+
+	// frames are paired up by index, which only lines them up in time at the same frame rate
+	if mezzanineInfo != nil {
+		mezzanineStream := mezzanineInfo.Streams[0]
+		var mismatched []string
+		for i := range sortedVariants {
+			stream := variantInfo[i].Streams[0]
+			if !stream.SameFrameRate(mezzanineStream) {
+				mismatched = append(mismatched, fmt.Sprintf("variant %d is %s fps against the mezzanine's %s fps", i, stream.AvgFrameRate, mezzanineStream.AvgFrameRate))
+			}
+		}
+		if len(mismatched) > 0 {
+			if !cfg.AllowFPSMismatch {
+				return nil, fmt.Errorf("%d variants differ in frame rate from the mezzanine, so their frames can't be compared:\n  %s", len(mismatched), strings.Join(mismatched, "\n  "))
+			}
+			listing := strings.Join(mismatched, "; ")
+			slog.Warn("Continuing despite variants differing in frame rate from the mezzanine, scores compare misaligned frames", "variants", len(mismatched), "mismatches", listing)
+		}
+	}
+
+	// scoredFrames is how many frames of a variant and the mezzanine are scored against each other
+	scoredFrames := func(variant int) uint64 {
+		if variantInfo[variant].FrameCount() < mezzanineInfo.FrameCount() {
+			return variantInfo[variant].FrameCount()
+		}
+		return mezzanineInfo.FrameCount()
+	}
+
+	for i := range sortedVariants {
+		// without a mezzanine there is nothing to align against
+		if mezzanineInfo == nil {
+			break
+		}
+
+		// a window of DASH segments only covers the start of the mezzanine
+		if windowed && variantInfo[i].FrameCount() <= mezzanineInfo.FrameCount() {
+			slog.Info("Variant info looks good", "variant", i, "frames", variantInfo[i].FrameCount(), "mezzanine_frames", mezzanineInfo.FrameCount())
+			continue
+		}
+
+		// encoders may drop or duplicate the odd frame, both inputs are scored over the shorter length
+		delta := int64(variantInfo[i].FrameCount()) - int64(mezzanineInfo.FrameCount())
+		if delta < -int64(cfg.FrameTolerance) || delta > int64(cfg.FrameTolerance) {
+			return nil, fmt.Errorf("Variant frame count doesn't match mezzanine frame count: %d != %d", variantInfo[i].FrameCount(), mezzanineInfo.FrameCount())
+		}
+		if delta != 0 {
+			slog.Warn("Variant frame count differs from the mezzanine, scoring the shorter length of both", "variant", i, "frames", variantInfo[i].FrameCount(), "mezzanine_frames", mezzanineInfo.FrameCount(), "delta", delta, "scored_frames", scoredFrames(i))
+			continue
+		}
+
+		slog.Info("Variant info looks good", "variant", i, "frames", variantInfo[i].FrameCount())
+	}
+
+	// both inputs must still line up once the boundary frames are dropped
+	if mezzanineInfo != nil && (cfg.TrimFramesStart > 0 || cfg.TrimFramesEnd > 0) {
+		for i := range sortedVariants {
+			total := scoredFrames(i)
+			if cfg.TrimFramesStart+cfg.TrimFramesEnd >= total {
+				return nil, fmt.Errorf("Trimming %d frames from the start and %d from the end leaves nothing of variant %d's %d frames", cfg.TrimFramesStart, cfg.TrimFramesEnd, i, total)
+			}
+			slog.Info("Trimmed frames", "variant", i, "first_frame", cfg.TrimFramesStart, "last_frame", total-cfg.TrimFramesEnd-1, "frames", total)
+		}
+	}
+
+	// summary of the run
+	report := &RunReport{
+		Model:             cfg.Model,
+		ModelSHA256:       modelHash,
+		Reference:         "mezzanine",
+		Pool:              string(cpuVMAF.PoolMethod),
+		Scaler:            scaler,
+		Transform:         cpuVMAF.Transform(),
+		Metric:            string(cfg.Metric),
+		Partial:           cfg.OnlyVariant != nil || cfg.OnlyResolution != "",
+		LogsDir:           logsPath,
+		CompareResolution: cfg.CompareResolution,
+		TrimFramesStart:   cfg.TrimFramesStart,
+		TrimFramesEnd:     cfg.TrimFramesEnd,
+		Variants:          make([]VariantReport, len(sortedVariants)),
+	}
+	if relative {
+		report.Reference = "top-variant"
+	}
+	// record what produced the scores
+	var ffmpegPath, ffprobePath string
+	if ffmpeg != nil {
+		ffmpegPath, ffprobePath = ffmpeg.FFmpegPath, ffmpeg.FFprobePath
+	}
+	report.Versions = detectToolVersions(ctx, ffmpegPath, ffprobePath, cfg.Estimator)
+	if videoStream != nil {
+		report.MezzanineWidth, report.MezzanineHeight = videoStream.Width, videoStream.Height
+	}
+	for i, variant := range sortedVariants {
+		stream := variantInfo[i].Streams[0]
+		report.Variants[i] = VariantReport{
+			URI:        variant.URI,
+			Path:       dumpPath(i),
+			Bandwidth:  variant.Bandwidth,
+			Width:      stream.Width,
+			Height:     stream.Height,
+			FrameCount: variantInfo[i].FrameCount(),
+			Duration:   variantInfo[i].Duration(),
+			Codec:      stream.CodecName,
+			Profile:    stream.Profile,
+			Level:      stream.LevelName(),
+		}
+		slog.Info("Variant encode", "variant", i, "codec", stream.CodecName, "profile", stream.Profile, "level", stream.LevelName())
+	}
+
+	// spot variants the manifest describes wrongly
+	if cfg.VariantAttributes {
+		for i, variant := range sortedVariants {
+			attrs := variantAttributes(variant, variantInfo[i].Streams[0])
+			report.Variants[i].Attributes = attrs
+			slog.Info("Variant attributes", "variant", i, "codecs", attrs.Codecs, "resolution", attrs.Resolution, "frame_rate", attrs.FrameRate, "video_range", attrs.VideoRange, "hdcp_level", attrs.HDCPLevel,
+				"probed_codec", attrs.ProbedCodec, "probed_resolution", attrs.ProbedResolution, "probed_frame_rate", attrs.ProbedFrameRate)
+			for _, mismatch := range attrs.Mismatches {
+				slog.Warn("Variant attribute mismatch", "variant", i, "mismatch", mismatch)
+			}
+		}
+	}
+
+	// just hand the dumped variants over for use in other tools
+	if cfg.DumpOnly {
+		return report, nil
+	}
+
+	// decode the mezzanine to disk once per resolution rather than once per variant
+	var mezzCache *mezzanineCache
+	if cfg.MezzanineCacheDir != "" && !cfg.DryRun {
+		if mezzCache, err = newMezzanineCache(cfg.MezzanineCacheDir, decoder, pixelFormat); err != nil {
+			return nil, fmt.Errorf("Failed to create mezzanine cache in %q: %v", cfg.MezzanineCacheDir, err)
+		}
+		defer mezzCache.cleanup()
+	}
+
+	// map each variant's media segments onto frame ranges
+	variantSegments := make([][]SegmentBound, len(sortedVariants))
+	if cfg.SegmentScores {
+		for i, variant := range sortedVariants {
+			playlistURL, err := resolveURI(manifestURL, variant.URI)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid variant URI %q: %v", variant.URI, err)
+			}
+			playlist, playlistType, err := fetchPlaylist(ctx, playlistURL, fetcher)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to load variant playlist: %v", err)
+			}
+			if playlistType != m3u8.MEDIA {
+				return nil, fmt.Errorf("Variant %d playlist %q is not a media playlist", i, playlistURL)
+			}
+			fps := variantInfo[i].Streams[0].FrameRate()
+			if fps == 0 {
+				return nil, fmt.Errorf("Variant %d has no known frame rate to map segments onto frames", i)
+			}
+			variantSegments[i] = segmentBounds(playlist.(*m3u8.MediaPlaylist), fps)
+			slog.Info("Variant segments", "variant", i, "segments", len(variantSegments[i]), "fps", fps)
+		}
+	}
+
+	// find the repetition period of looping content
+	loopPeriod := 0
+	if cfg.DetectLoops && !cfg.DryRun {
+		hashes, err := decoder.FrameHashes(ctx, mezzanineFile)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to hash mezzanine frames: %v", err)
+		}
+		if loopPeriod = detectLoopPeriod(hashes); loopPeriod > 0 {
+			slog.Info("Mezzanine loops", "period", loopPeriod, "repetitions", len(hashes)/loopPeriod)
+		} else {
+			slog.Info("No repeating content detected in the mezzanine")
+		}
+	}
+
+	// trimmedRange is the [start, end) range of frames scored for a variant when trimming
+	// only the selected frames of both inputs are decoded, by their frame numbers in the mezzanine
+	if cfg.FrameSelect != nil && mezzanineInfo != nil {
+		selection := *cfg.FrameSelect
+		if selection.Keyframes {
+			fps := mezzanineInfo.Streams[0].FrameRate()
+			if fps == 0 {
+				return nil, fmt.Errorf("The mezzanine has no known frame rate to number its keyframes by")
+			}
+			times, err := decoder.KeyframeTimes(ctx, mezzanineFile)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to find the mezzanine's keyframes: %v", err)
+			}
+			selection.Frames = keyframeIndexes(times, fps)
+		}
+		selected := selection.Count(cfg.TrimFramesStart, mezzanineInfo.FrameCount()-cfg.TrimFramesEnd)
+		if selected == 0 {
+			return nil, fmt.Errorf("--frame-select doesn't select any of the mezzanine's %d frames", mezzanineInfo.FrameCount())
+		}
+		ffmpeg.FrameSelect = selection.Expr()
+		slog.Info("Scoring selected frames", "frames", selected, "mezzanine_frames", mezzanineInfo.FrameCount())
+	}
+
+	// long content is scored a window at a time, each window after the first starting on a keyframe
+	// of the mezzanine so its decode can seek straight there
+	var keyframes []uint64
+	var windowFrames uint64
+	var windowFPS float64
+	if cfg.SegmentDuration > 0 && mezzanineInfo != nil {
+		if windowFPS = mezzanineInfo.Streams[0].FrameRate(); windowFPS == 0 {
+			return nil, fmt.Errorf("The mezzanine has no known frame rate to split into %s windows", cfg.SegmentDuration)
+		}
+		if windowFrames = uint64(math.Round(cfg.SegmentDuration.Seconds() * windowFPS)); windowFrames == 0 {
+			windowFrames = 1
+		}
+		times, err := decoder.KeyframeTimes(ctx, mezzanineFile)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to find the mezzanine's keyframes: %v", err)
+		}
+		keyframes = keyframeIndexes(times, windowFPS)
+		slog.Info("Scoring in windows", "segment_duration", cfg.SegmentDuration, "window_frames", windowFrames, "keyframes", len(keyframes))
+	}
+	// windowSeek is where a window's decodes seek to, half a frame early so rounding can't drop its
+	// first frame
+	windowSeek := func(window frameWindow) float64 {
+		return math.Max(0, (float64(window.start)-0.5)/windowFPS)
+	}
+
+	trimmed := cfg.TrimFramesStart > 0 || cfg.TrimFramesEnd > 0
+	trimmedRange := func(variant int) (uint64, uint64) {
+		return cfg.TrimFramesStart, scoredFrames(variant) - cfg.TrimFramesEnd
+	}
+	// readLog renumbers the frames of a VMAF log to their position in the untrimmed input
+	readLog := func(variant int, width, height uint64) (*VMAFLog, error) {
+		vmafLog, err := vmaf.ReadLog(uint64(variant), width, height)
+		if err != nil {
+			return nil, err
+		}
+		for _, frame := range vmafLog.Frames {
+			frame.FrameNum += int(cfg.TrimFramesStart)
+		}
+		return vmafLog, nil
+	}
+
+	// scoreResolution decodes the mezzanine and the job's variant to its resolution into the slot's
+	// FIFOs and runs VMAF over the pair, also reporting whether VMAF was skipped because the frames
+	// were identical to the reference
+	scoreResolution := func(ctx context.Context, slot *vmafSlot, job scoreJob) (outcome scoreOutcome) {
+		variant, curWidth, curHeight := job.variant, job.width, job.height
+		slog.Debug("Calculating VMAF score", "variant", variant, resolution(curWidth, curHeight))
+		progress.Scoring(variant, curWidth, curHeight)
+		start := time.Now()
+		defer func() {
+			outcome.elapsed = time.Since(start)
+		}()
+
+		cancelCtx, cancelFunc := context.WithCancel(ctx)
+		defer cancelFunc()
+
+		// only decode as much of either input as the other covers, which for a windowed
+		// variant is the start of the mezzanine
+		referenceFrames, distortedFrames := uint64(0), uint64(0)
+		if total := scoredFrames(variant); total < mezzanineInfo.FrameCount() {
+			referenceFrames = total
+		} else if total < variantInfo[variant].FrameCount() {
+			distortedFrames = total
+		}
+		trimStart, trimEnd := trimmedRange(variant)
+
+		// a variant at its own resolution may be a lossless copy of the reference, which scores perfectly
+		if stream := variantInfo[variant].Streams[0]; cfg.SkipIdentical && stream.Width == curWidth && stream.Height == curHeight {
+			identical, err := framesIdentical(cancelCtx, decoder, mezzanineFile, dumpPath(variant), curWidth, curHeight, trimStart, trimEnd)
+			if err != nil {
+				slog.Warn("Unable to compare frame hashes, running VMAF", "error", err)
+			} else if identical {
+				slog.Info("Variant is identical to the reference, skipping VMAF", "variant", variant, resolution(curWidth, curHeight), "vmaf", identicalVMAF)
+				if !relative {
+					reason := fmt.Sprintf("Variant %d decodes to the same frames as the mezzanine", variant)
+					if outcome.err = suspectSwapped(reason, "variant", variant); outcome.err != nil {
+						return outcome
+					}
+				}
+				if cfg.SegmentScores {
+					for _, bound := range variantSegments[variant] {
+						outcome.segments = append(outcome.segments, SegmentScore{Variant: variant, Width: curWidth, Height: curHeight, SegmentBound: bound, VMAF: identicalVMAF})
+					}
+				}
+				result := identicalResult
+				result.Metric = cfg.Metric
+				outcome.result, outcome.identical = &result, true
+				return outcome
+			}
+		}
+
+		// wait for VMAF threads to free up before starting the decodes, which would otherwise block
+		// on the FIFOs holding their processes
+		if err := budget.Acquire(cancelCtx, int(cfg.Threads)); err != nil {
+			outcome.err = err
+			return outcome
+		}
+		defer budget.Release(int(cfg.Threads))
+
+		// scorePair decodes both inputs into the slot's FIFOs and runs VMAF over the pair, only over
+		// the frames of window when there is one
+		scorePair := func(window *frameWindow) (*VMAFResult, error) {
+			var decodes sync.WaitGroup
+			// decoded is closed once both decodes have returned, for the VMAF watchdog
+			decoded := make(chan struct{})
+			decodes.Add(2)
+			go func() {
+				decodes.Wait()
+				close(decoded)
+			}()
+
+			// decode reference
+			decodeReference := func(ctx context.Context) error {
+				defer decodes.Done()
+				slog.Debug("Decoding", "file", mezzanineFile)
+				defer progress.Time(StageDecode, time.Now())
+				start, end := uint64(0), referenceFrames
+				if trimmed {
+					start, end = trimStart, trimEnd
+				}
+				if window != nil {
+					start, end = window.start, window.end
+				}
+				var err error
+				if path, ok := cfg.ReferenceYUV[resolutionKey(curWidth, curHeight)]; ok {
+					err = streamYUV(ctx, path, slot.mezzaninePath, pixelFormat.FrameSize(curWidth, curHeight), start, end, cfg.PipeSize)
+				} else if mezzCache != nil {
+					err = mezzCache.stream(ctx, mezzanineFile, slot.mezzaninePath, curWidth, curHeight, start, end, cfg.PipeSize)
+				} else if window != nil {
+					err = decoder.DecodeWindowToWidthAndHeight(ctx, mezzanineFile, slot.mezzaninePath, curWidth, curHeight, windowSeek(*window), window.end-window.start)
+				} else if trimmed {
+					err = decoder.DecodeFrameRangeToWidthAndHeight(ctx, mezzanineFile, slot.mezzaninePath, curWidth, curHeight, trimStart, trimEnd)
+				} else {
+					err = decoder.DecodeFramesToWidthAndHeight(ctx, mezzanineFile, slot.mezzaninePath, curWidth, curHeight, referenceFrames)
+				}
+				if err != nil {
+					slog.Error("Failed decoding mezzanine", "error", err)
+				}
+				return err
+			}
+
+			// decode distorted
+			decodeDistorted := func(ctx context.Context) error {
+				defer decodes.Done()
+				distoredFile := dumpPath(variant)
+
+				slog.Debug("Decoding", "file", distoredFile)
+				defer progress.Time(StageDecode, time.Now())
+				var err error
+				if window != nil {
+					err = decoder.DecodeWindowToWidthAndHeight(ctx, distoredFile, slot.distortedPath, curWidth, curHeight, windowSeek(*window), window.end-window.start)
+				} else if trimmed {
+					err = decoder.DecodeFrameRangeToWidthAndHeight(ctx, distoredFile, slot.distortedPath, curWidth, curHeight, trimStart, trimEnd)
+				} else {
+					err = decoder.DecodeFramesToWidthAndHeight(ctx, distoredFile, slot.distortedPath, curWidth, curHeight, distortedFrames)
+				}
+				if err != nil {
+					slog.Error("Failed decoding variant", "variant", variant, "error", err)
+				}
+				return err
+			}
+
+			// calculate VMAF score
+			var result *VMAFResult
+			calculateVMAF := func(ctx context.Context) error {
+				vmafStart := time.Now()
+				vmafResult, vmafErr := slot.vmaf.CalculateVMAF(ctx, uint64(variant), curWidth, curHeight)
+				progress.Time(StageVMAF, vmafStart)
+				if vmafErr != nil {
+					slog.Error("Failed calculating VMAF", "variant", variant, "error", vmafErr)
+					return vmafErr
+				}
+				if cfg.Metric == "" && vmafResult.VMAF < cfg.LowVMAFThreshold {
+					return fmt.Errorf("Low vmaf score detected, most likely due to misconfiguration such as swapped inputs. Score %f is below --low-vmaf-threshold %f", vmafResult.VMAF, cfg.LowVMAFThreshold)
+				}
+				attrs := []interface{}{"variant", variant, resolution(curWidth, curHeight), "pool", cpuVMAF.PoolMethod, "vmaf", roundScore(vmafResult.VMAF, cfg.ResultPrecision)}
+				if cfg.Metric == "" {
+					spread := vmafResult.Spread
+					attrs = append(attrs, "stddev", roundScore(spread.StdDev, cfg.ResultPrecision), "p5", roundScore(spread.P5, cfg.ResultPrecision), "p95", roundScore(spread.P95, cfg.ResultPrecision), "min", roundScore(spread.Min, cfg.ResultPrecision))
+				}
+				if logged.PSNR {
+					attrs = append(attrs, "psnr", roundScore(vmafResult.PSNR, cfg.ResultPrecision))
+				}
+				if logged.SSIM {
+					attrs = append(attrs, "ssim", roundScore(vmafResult.SSIM, cfg.ResultPrecision))
+				}
+				if logged.MSSSIM {
+					attrs = append(attrs, "ms_ssim", roundScore(vmafResult.MSSSIM, cfg.ResultPrecision))
+				}
+				slog.Debug("Calculated VMAF", attrs...)
+
+				result = vmafResult
+
+				// with VMAF gone a decode still writing to its FIFO would block forever
+				return watchDecodes(decoded, slot.mezzaninePath, slot.distortedPath)
+			}
+
+			err := runConcurrent(cancelCtx, decodeReference, decodeDistorted, calculateVMAF)
+			return result, err
+		}
+
+		// long content is scored a window at a time and the windows pooled together
+		var vmafResult *VMAFResult
+		var runErr error
+		if windowFrames > 0 {
+			first, last := uint64(0), scoredFrames(variant)
+			if trimmed {
+				first, last = trimStart, trimEnd
+			}
+			windows := frameWindows(keyframes, windowFrames, first, last)
+			var results []*VMAFResult
+			var frameScores []float64
+			for i := range windows {
+				result, err := scorePair(&windows[i])
+				if err != nil {
+					runErr = err
+					break
+				}
+				slog.Debug("Scored window", "variant", variant, resolution(curWidth, curHeight), "window", i+1, "windows", len(windows), "start_frame", windows[i].start, "end_frame", windows[i].end, "vmaf", roundScore(result.VMAF, cfg.ResultPrecision))
+				results = append(results, result)
+				// the spread is over every frame, which each window's log is overwritten with the next
+				if cpuVMAF.scoresVMAF() {
+					vmafLog, err := vmaf.ReadLog(uint64(variant), curWidth, curHeight)
+					if err != nil {
+						runErr = err
+						break
+					}
+					frameScores = append(frameScores, extractMetric(vmafLog.Frames, "vmaf")...)
+				}
+			}
+			if runErr == nil {
+				vmafResult = combineWindowResults(results, windows, cpuVMAF.PoolMethod)
+				if scores, _, err := applyZeroPolicy(frameScores, cpuVMAF.ZeroPolicy); err == nil && len(scores) > 0 {
+					vmafResult.Spread = frameSpread(scores)
+				}
+				slog.Info("Pooled windows", "variant", variant, resolution(curWidth, curHeight), "windows", len(windows), "vmaf", roundScore(vmafResult.VMAF, cfg.ResultPrecision))
+			}
+		} else {
+			vmafResult, runErr = scorePair(nil)
+		}
+		if runErr != nil {
+			slog.Error("Failed running VMAF", "error", runErr)
+		}
+
+		// keep every frame's scores for debugging quality dips
+		if runErr == nil && cfg.PerFrameCSV {
+			vmafLog, err := readLog(variant, curWidth, curHeight)
+			if err != nil {
+				outcome.err = err
+				return outcome
+			}
+			csvPath := cpuVMAF.FramesCSVPath(uint64(variant), curWidth, curHeight)
+			if err := writeFramesCSV(csvPath, vmafLog.Frames, logged); err != nil {
+				outcome.err = fmt.Errorf("Failed to write per-frame CSV %q: %v", csvPath, err)
+				return outcome
+			}
+			slog.Info("Wrote per-frame scores", "path", csvPath)
+		}
+
+		// pool the per-frame scores by the media segment they belong to
+		if runErr == nil && cfg.SegmentScores {
+			vmafLog, err := readLog(variant, curWidth, curHeight)
+			if err != nil {
+				outcome.err = err
+				return outcome
+			}
+			scores, err := segmentScores(vmafLog.Frames, variantSegments[variant], cpuVMAF.PoolMethod, cpuVMAF.ZeroPolicy)
+			if err != nil {
+				outcome.err = err
+				return outcome
+			}
+			for _, score := range scores {
+				score.Variant, score.Width, score.Height = variant, curWidth, curHeight
+				score.VMAF = roundScore(score.VMAF, cfg.ResultPrecision)
+				outcome.segments = append(outcome.segments, score)
+			}
+		}
+
+		// the same content should score the same each time it repeats
+		if runErr == nil && loopPeriod > 0 {
+			vmafLog, err := readLog(variant, curWidth, curHeight)
+			if err != nil {
+				outcome.err = err
+				return outcome
+			}
+			scores, err := repetitionScores(vmafLog.Frames, loopPeriod, int(scoredFrames(variant)), cpuVMAF.PoolMethod, cpuVMAF.ZeroPolicy)
+			if err != nil {
+				outcome.err = err
+				return outcome
+			}
+			if drift := scoreDrift(scores); drift > loopDriftThreshold {
+				slog.Warn("VMAF drifts across repetitions", "variant", variant, resolution(curWidth, curHeight), "repetitions", len(scores), "drift", roundScore(drift, cfg.ResultPrecision))
+			} else {
+				slog.Info("VMAF is stable across repetitions", "variant", variant, resolution(curWidth, curHeight), "repetitions", len(scores), "drift", roundScore(drift, cfg.ResultPrecision))
+			}
+		}
+		outcome.result, outcome.err = vmafResult, runErr
+		return outcome
+	}
+
+	// quality gate, either checked once the sweep completes or the moment a score drops below it.
+	// An abort stops the pipeline but still returns the report of the scores so far.
+	var lowScores []string
+	var aborted *LowScoreError
+	checkQualityGate := func(variant int, width, height uint64, score float64) error {
+		if cfg.MinVMAF <= 0 || score >= cfg.MinVMAF {
+			return nil
+		}
+		low := fmt.Sprintf("variant %d (%d bps) at %dx%d scored %s", variant, sortedVariants[variant].Bandwidth, width, height, formatScore(score, cfg.ResultPrecision))
+		if cfg.AbortOnFirstLow {
+			aborted = &LowScoreError{MinVMAF: cfg.MinVMAF, Low: []string{low}, Aborted: true}
+			return aborted
+		}
+		lowScores = append(lowScores, low)
+		return nil
+	}
+	qualityGateErr := func() error {
+		if aborted != nil {
+			return aborted
+		}
+		if len(lowScores) == 0 {
+			return nil
+		}
+		return &LowScoreError{MinVMAF: cfg.MinVMAF, Low: lowScores}
+	}
+	recordScore := func(job scoreJob, outcome scoreOutcome) {
+		progress.Scored()
+		result := outcome.result
+		report.Scores = append(report.Scores, ResolutionScore{
+			Variant:         job.variant,
+			Width:           job.width,
+			Height:          job.height,
+			VMAF:            roundScore(result.VMAF, cfg.ResultPrecision),
+			PSNR:            roundScore(result.PSNR, cfg.ResultPrecision),
+			SSIM:            roundScore(result.SSIM, cfg.ResultPrecision),
+			MSSSIM:          roundScore(result.MSSSIM, cfg.ResultPrecision),
+			Identical:       outcome.identical,
+			VMAFStdDev:      roundScore(result.Spread.StdDev, cfg.ResultPrecision),
+			VMAFP5:          roundScore(result.Spread.P5, cfg.ResultPrecision),
+			VMAFP95:         roundScore(result.Spread.P95, cfg.ResultPrecision),
+			VMAFMin:         roundScore(result.Spread.Min, cfg.ResultPrecision),
+			DurationSeconds: outcome.elapsed.Seconds(),
+		})
+		report.Segments = append(report.Segments, outcome.segments...)
+	}
+
+	// score each variant once at a fixed resolution, skipping the user population grid
+	if cfg.CompareResolution != "" {
+		var jobs []scoreJob
+		for i := range sortedVariants {
+			curWidth, curHeight, err := compareDimensions(cfg.CompareResolution, videoStream, variantInfo[i].Streams[0], profile.MinResolution)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid comparison resolution for variant %d: %v", i, err)
+			}
+			if onlyPair(i, curWidth, curHeight) {
+				jobs = append(jobs, scoreJob{i, curWidth, curHeight})
+			}
+		}
+		if len(jobs) == 0 {
+			return nil, fmt.Errorf("No variant is compared at --only-resolution %s", cfg.OnlyResolution)
+		}
+		progress.SetTotal(len(jobs))
+		if cfg.DryRun {
+			for _, job := range jobs {
+				slog.Info("Dry run: would score variant", "variant", job.variant, "bandwidth", sortedVariants[job.variant].Bandwidth, resolution(job.width, job.height))
+			}
+			return report, nil
+		}
+		if err := prepareVMAF(logsPath, fifos...); err != nil {
+			return nil, err
+		}
+		err := runPipeline(ctx, jobs, slots, scoreResolution, func(job scoreJob, outcome scoreOutcome) error {
+			if outcome.err != nil {
+				return &StageError{StageVMAF, fmt.Errorf("Error running vmaf calculation: %v", outcome.err)}
+			}
+			i, vmafScore := job.variant, outcome.result.Score()
+			slog.Info("Variant score", "variant", i, "bandwidth", sortedVariants[i].Bandwidth, resolution(job.width, job.height), "metric", scoreLabel, "score", roundScore(vmafScore, cfg.ResultPrecision))
+			recordScore(job, outcome)
+			rounded := roundScore(vmafScore, cfg.ResultPrecision)
+			report.Variants[i].VMAF = &rounded
+			return checkQualityGate(i, job.width, job.height, vmafScore)
+		})
+		if err != nil && aborted == nil {
+			return nil, err
+		}
+		if referenceLadder != nil {
+			if report.ReferenceLadder, err = compareLadders(report, referenceLadder, cfg.ReferenceLadder, cfg.ResultPrecision); err != nil {
+				return nil, fmt.Errorf("Failed to compare against the reference ladder: %v", err)
+			}
+		}
+		progress.Finish()
+		report.StageSeconds = progress.StageSeconds()
+		return report, qualityGateErr()
+	}
+
+	// read from user data file, without the origin's credentials when it's fetched
+	dataFetcher := &HTTPFetcher{Client: fetcher.Client, Retries: cfg.HTTPRetries}
+	rawFile, err := readDataFile(ctx, cfg.DataFile, dataFetcher)
+	if err != nil {
+		return nil, err
+	}
+
+	// parse data and validate
+	data, err := parseDataFile(rawFile)
+	if err != nil {
+		return nil, err
+	}
+	gridWidths, err := data.resolutionGrid()
+	if err != nil {
+		return nil, err
+	}
+	// the rest of the run averages over whichever distributions were picked
+	if data.ResolutionPcts, data.BandwidthPcts, err = data.weights(cfg.WeightBy); err != nil {
+		return nil, err
+	}
+	if cfg.WeightBy != "" {
+		report.WeightBy = cfg.WeightBy
+	}
+	buckets := cfg.BandwidthBuckets
+	if buckets == 0 {
+		buckets = bandwidthsLen
+	}
+	bucketWidth := uint64(cfg.BandwidthBucketKbps) * 1000
+	if bucketWidth == 0 {
+		bucketWidth = bandwidthBucketKbps * 1000
+	}
+	if err := validateDistribution("bandwidth", data.BandwidthPcts, buckets); err != nil {
+		return nil, err
+	}
+	if err := validateDistribution("resolution", data.ResolutionPcts, len(gridWidths)); err != nil {
+		return nil, err
+	}
+	dataSums := cfg.DataSums
+	if dataSums == (DataSumPolicy{}) {
+		dataSums.Epsilon = distributionEpsilon
+	}
+	if data.BandwidthPcts, err = dataSums.check("bandwidth", data.BandwidthPcts); err != nil {
+		return nil, err
+	}
+	if data.ResolutionPcts, err = dataSums.check("resolution", data.ResolutionPcts); err != nil {
+		return nil, err
+	}
+	slog.Info("Loaded data file", "bandwidths", len(data.BandwidthPcts), "bandwidth_sum", sumFloat64Array(data.BandwidthPcts), "resolutions", len(data.ResolutionPcts), "resolution_sum", sumFloat64Array(data.ResolutionPcts))
+
+	// calculate user bandwidth percentile within variant
+	userPcts := bandwidthUserPcts(data.BandwidthPcts, bucketWidth, sortedVariants)
+	lastBucket := uint64(buckets-1) * bucketWidth
+	for i, variant := range sortedVariants {
+		if uint64(variant.Bandwidth) > lastBucket {
+			slog.Warn("Variant is above the last bandwidth bucket, no users can be attributed to it; raise --bandwidth-buckets", "variant", i, "bandwidth", variant.Bandwidth, "last_bucket", lastBucket)
+		}
+	}
+	for i, totalPct := range userPcts {
+		if i == 0 {
+			slog.Info("Users with insufficient bandwidth for any rendition to play smoothly", "users", formatShare(totalPct, cfg.PercentFormat))
+		} else {
+			slog.Info("Users with sufficient bandwidth for rendition", "rendition", i, "users", formatShare(totalPct, cfg.PercentFormat))
+		}
+	}
+
+	// skipReason explains why a bandwidth bucket and resolution bucket pair isn't scored,
+	// or is empty when it is
+	skipReason := func(i, j int) string {
+		curWidth := gridWidths[j]
+		curHeight := widthToHeight(curWidth, videoStream)
+		if curWidth < profile.MinResolution || curHeight < profile.MinResolution {
+			return fmt.Sprintf("it's below the minimum VMAF resolution of %d", profile.MinResolution)
+		}
+		isNativeBucket := (cfg.Pareto || referenceLadder != nil) && j == nativeResolutionBucket(gridWidths, variantInfo[i-1].Streams[0].Width)
+		if data.ResolutionPcts[j] == 0.0 && !isNativeBucket {
+			return "zero percentage of users watch at this resolution"
+		}
+		if !onlyPair(i-1, curWidth, curHeight) {
+			return "it's outside of --only-variant and --only-resolution"
+		}
+		return ""
+	}
+	if cfg.OnlyResolution != "" {
+		if nativeResolutionBucket(gridWidths, onlyWidth) < 0 || widthToHeight(onlyWidth, videoStream) != onlyHeight {
+			return nil, fmt.Errorf("--only-resolution %s isn't in the resolution grid of the data file's widths at the mezzanine's aspect ratio", cfg.OnlyResolution)
+		}
+	}
+	total := 0
+	for i := 1; i < len(userPcts); i++ {
+		scored := 0
+		for j := range data.ResolutionPcts {
+			if skipReason(i, j) == "" {
+				scored++
+			}
+		}
+		if scored == 0 && userPcts[i] > 0 && !report.Partial {
+			slog.Warn("No resolutions are scored for variant, its users count as a VMAF of 0", "variant", i-1, "users", formatShare(userPcts[i], cfg.PercentFormat))
+		}
+		total += scored
+	}
+	// an average over nothing would be reported as a VMAF of 0
+	if total == 0 && report.Partial {
+		return nil, fmt.Errorf("--only-variant and --only-resolution leave no variant and resolution pairs to score")
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("No variant and resolution pairs to score, every resolution is either below the %s model's minimum of %d or watched by no users", profile.Name, profile.MinResolution)
+	}
+	progress.SetTotal(total)
+
+	// print the grid instead of scoring it
+	if cfg.DryRun {
+		for i := 1; i < len(userPcts); i++ {
+			var resolutions []string
+			for j := range data.ResolutionPcts {
+				if skipReason(i, j) == "" {
+					curWidth := gridWidths[j]
+					resolutions = append(resolutions, fmt.Sprintf("%dx%d", curWidth, widthToHeight(curWidth, videoStream)))
+				}
+			}
+			slog.Info("Dry run: would score variant", "variant", i-1, "bandwidth", sortedVariants[i-1].Bandwidth, "users", formatShare(userPcts[i], cfg.PercentFormat), "resolutions", strings.Join(resolutions, " "))
+		}
+		slog.Info("Dry run: would score variant and resolution pairs", "pairs", total)
+		report.UserPcts = userPcts
+		return report, nil
+	}
+
+	// calculate VMAF for users on bandwidth buckets
+	if err := prepareVMAF(logsPath, fifos...); err != nil {
+		return nil, err
+	}
+	effectiveVmafs := make([][]float64, len(userPcts))
+	var jobs []scoreJob
+	for i := range userPcts {
+		effectiveVmafs[i] = make([]float64, len(data.ResolutionPcts))
+		if i == 0 {
+			continue
+		}
+
+		// calculate vmaf score resolutions at current bitrate bucket
+		for j := range data.ResolutionPcts {
+			curWidth := gridWidths[j]
+			curHeight := widthToHeight(curWidth, videoStream)
+
+			if reason := skipReason(i, j); reason != "" {
+				slog.Debug("Skipping resolution", resolution(curWidth, curHeight), "reason", reason)
+				continue
+			}
+			jobs = append(jobs, scoreJob{i - 1, curWidth, curHeight})
+		}
+	}
+	err = runPipeline(ctx, jobs, slots, scoreResolution, func(job scoreJob, outcome scoreOutcome) error {
+		if outcome.err != nil {
+			return &StageError{StageVMAF, fmt.Errorf("Error running vmaf calculation: %v", outcome.err)}
+		}
+		vmafScore := outcome.result.Score()
+
+		// fill in and print effective VMAF score
+		i, j := job.variant+1, nativeResolutionBucket(gridWidths, job.width)
+		effectiveVmafs[i][j] = vmafScore
+		recordScore(job, outcome)
+		slog.Info("Scored resolution", "variant", job.variant, resolution(job.width, job.height), "vmaf", roundScore(vmafScore, cfg.ResultPrecision), "bitrate_users", formatShare(userPcts[i], cfg.PercentFormat), "resolution_users", formatShare(data.ResolutionPcts[j], cfg.PercentFormat))
+		return checkQualityGate(job.variant, job.width, job.height, vmafScore)
+	})
+	if err != nil && aborted == nil {
+		return nil, err
+	}
+
+	// calculate acg VMAF score
+	totalVmaf := float64(0.0)
+	for i, bitratePct := range userPcts {
+		for j, resPct := range data.ResolutionPcts {
+			totalVmaf += effectiveVmafs[i][j] * bitratePct * resPct
+		}
+	}
+
+	report.UserPcts = userPcts
+	report.EffectiveVMAFs = make([][]float64, len(effectiveVmafs))
+	for i, row := range effectiveVmafs {
+		report.EffectiveVMAFs[i] = make([]float64, len(row))
+		for j, score := range row {
+			report.EffectiveVMAFs[i][j] = roundScore(score, cfg.ResultPrecision)
+		}
+	}
+	report.ResolutionWidths = gridWidths
+	report.AverageVMAF = roundScore(totalVmaf, cfg.ResultPrecision)
+
+	// calculate the pareto frontier from each variant's native resolution score
+	if cfg.Pareto {
+		var points []*ParetoPoint
+		for i, variant := range sortedVariants {
+			stream := variantInfo[i].Streams[0]
+			bucket := nativeResolutionBucket(gridWidths, stream.Width)
+			if bucket < 0 || effectiveVmafs[i+1][bucket] == 0.0 {
+				slog.Info("Excluding variant from the Pareto frontier, no VMAF score at its native resolution", "variant", i, resolution(stream.Width, stream.Height))
+				continue
+			}
+			points = append(points, &ParetoPoint{
+				Variant:   i,
+				Bandwidth: variant.Bandwidth,
+				Width:     stream.Width,
+				Height:    stream.Height,
+				VMAF:      roundScore(effectiveVmafs[i+1][bucket], cfg.ResultPrecision),
+			})
+		}
+		report.Pareto = paretoFrontier(points)
+	}
+	if referenceLadder != nil {
+		if report.ReferenceLadder, err = compareLadders(report, referenceLadder, cfg.ReferenceLadder, cfg.ResultPrecision); err != nil {
+			return nil, fmt.Errorf("Failed to compare against the reference ladder: %v", err)
+		}
+	}
+	progress.Finish()
+	report.StageSeconds = progress.StageSeconds()
+	return report, qualityGateErr()
+}
+
+// removeIntermediates deletes the files a run created, skipping the user's mezzanine should
+// one share a path with them
+func removeIntermediates(paths []string, mezzanine string) {
+	keep := ""
+	if mezzanine != "" {
+		keep, _ = filepath.Abs(mezzanine)
+	}
+	for _, path := range paths {
+		if abs, err := filepath.Abs(path); err == nil && abs == keep {
+			continue
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			slog.Error("Failed to remove intermediate file", "path", path, "error", err)
+		}
+	}
+}
+
+// dumpVariants dumps every variant without probe output yet into variantInfo, running up to
+// concurrency dumps at once. The first failure cancels the dumps still running.
+func dumpVariants(ctx context.Context, decoder Decoder, variants []*m3u8.Variant, variantInfo []*FFProbeOutput, dumpPath func(int) string, concurrency int) error {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	dumpCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	fail := func(err error) {
+		mu.Lock()
+		if firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+		mu.Unlock()
+	}
+
+	pending := make(chan int)
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range pending {
+				slog.Info("Dumping variant", "variant", i)
+				info, err := decoder.DumpStream(dumpCtx, variants[i].URI, dumpPath(i))
+				if err != nil {
+					fail(fmt.Errorf("Failed to dump stream: %v", err))
+					continue
+				}
+				if len(info.Streams) != 1 {
+					fail(fmt.Errorf("Invalid variant stream has no video track"))
+					continue
+				}
+				// each worker only writes the indexes it was handed
+				variantInfo[i] = info
+			}
+		}()
+	}
+
+	for i := range variants {
+		if variantInfo[i] != nil {
+			continue
+		}
+		select {
+		case pending <- i:
+		case <-dumpCtx.Done():
+		}
+	}
+	close(pending)
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
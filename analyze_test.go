@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeDecoder stands in for ffmpeg, returning canned probes of the mezzanine and of each variant
+// by the base name of its URI. Decodes write the content of their input to the output, which the
+// fake vmafossexec reads back as the distorted input's score.
+type fakeDecoder struct {
+	mezzanine *FFProbeOutput
+	variants  map[string]*FFProbeOutput
+	// content is what decoding each file writes, by its base name
+	content map[string]string
+	// failDecode is the base name of a file whose decodes fail, once they've opened the output
+	failDecode string
+
+	mu     sync.Mutex
+	dumped []string
+}
+
+// fakeProbe is a probe of a single video stream of frames frames at 30fps
+func fakeProbe(width, height, frames uint64) *FFProbeOutput {
+	return &FFProbeOutput{Streams: []*FFProbeStream{{
+		Width:        width,
+		Height:       height,
+		NbReadFrames: ffprobeCount(frames),
+		AvgFrameRate: "30/1",
+		RFrameRate:   "30/1",
+		Duration:     fmt.Sprintf("%f", float64(frames)/30),
+	}}}
+}
+
+func (d *fakeDecoder) ProbeStreams(ctx context.Context, filename string) (*FFProbeOutput, error) {
+	if probe, ok := d.variants[filepath.Base(filename)]; ok {
+		return probe, nil
+	}
+	return d.mezzanine, nil
+}
+
+func (d *fakeDecoder) CountFrames(ctx context.Context, filename string) (uint64, error) {
+	probe, err := d.ProbeStreams(ctx, filename)
+	if err != nil {
+		return 0, err
+	}
+	return probe.FrameCount(), nil
+}
+
+func (d *fakeDecoder) DumpStream(ctx context.Context, variantURL, outputName string) (*FFProbeOutput, error) {
+	probe, ok := d.variants[filepath.Base(variantURL)]
+	if !ok {
+		return nil, fmt.Errorf("no canned probe for %s", variantURL)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.dumped = append(d.dumped, variantURL)
+	d.content[filepath.Base(outputName)] = d.content[filepath.Base(variantURL)]
+	return probe, nil
+}
+
+func (d *fakeDecoder) DecodeToWidthAndHeight(ctx context.Context, inputFile, outputFile string, width, height uint64) error {
+	return d.DecodeFrameRangeToWidthAndHeight(ctx, inputFile, outputFile, width, height, 0, 0)
+}
+
+func (d *fakeDecoder) DecodeFramesToWidthAndHeight(ctx context.Context, inputFile, outputFile string, width, height, frames uint64) error {
+	return d.DecodeFrameRangeToWidthAndHeight(ctx, inputFile, outputFile, width, height, 0, frames)
+}
+
+func (d *fakeDecoder) DecodeFrameRangeToWidthAndHeight(ctx context.Context, inputFile, outputFile string, width, height, start, end uint64) error {
+	d.mu.Lock()
+	content := d.content[filepath.Base(inputFile)]
+	d.mu.Unlock()
+	f, err := os.OpenFile(outputFile, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if filepath.Base(inputFile) == d.failDecode {
+		return fmt.Errorf("decoding %s failed", inputFile)
+	}
+	_, err = f.WriteString(content)
+	return err
+}
+
+func (d *fakeDecoder) DecodeWindowToWidthAndHeight(ctx context.Context, inputFile, outputFile string, width, height uint64, seek float64, frames uint64) error {
+	return d.DecodeFrameRangeToWidthAndHeight(ctx, inputFile, outputFile, width, height, 0, frames)
+}
+
+func (d *fakeDecoder) KeyframeTimes(ctx context.Context, filename string) ([]float64, error) {
+	return []float64{0}, nil
+}
+
+func (d *fakeDecoder) FrameHashes(ctx context.Context, filename string) ([]string, error) {
+	return nil, fmt.Errorf("fakeDecoder doesn't hash frames")
+}
+
+func (d *fakeDecoder) FrameHashesAtWidthAndHeight(ctx context.Context, filename string, width, height, start, end uint64) ([]string, error) {
+	return nil, fmt.Errorf("fakeDecoder doesn't hash frames")
+}
+
+// fakeScoringVMAFOSSExec puts a vmafossexec on PATH scoring every frame with the content of the
+// distorted FIFO, draining the reference FIFO first. The model preflight's regular files score 100.
+const fakeScoringVMAFOSSExec = `[ "$1" = "--version" ] && echo "VMAF 1.5.3" && exit 0
+reference=$4 distorted=$5
+shift 6
+while [ $# -gt 0 ]; do
+	[ "$1" = "--log" ] && log=$2
+	shift
+done
+score=100
+if [ -p "$distorted" ]; then
+	cat "$reference" > /dev/null
+	score=$(cat "$distorted")
+fi
+printf '{"frames":[{"frameNum":0,"metrics":{"vmaf":%s}},{"frameNum":1,"metrics":{"vmaf":%s}}]}' "$score" "$score" > "$log"
+`
+
+// analyzeFixture is the config of a run over a local two variant manifest, scored with
+// fakeDecoder and a fake vmafossexec so it needs neither ffmpeg nor the network
+func analyzeFixture(t *testing.T) (AnalyzeConfig, *fakeDecoder) {
+	t.Helper()
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	bin := fakeBinary(t, "vmafossexec", fakeScoringVMAFOSSExec)
+	t.Setenv("PATH", filepath.Dir(bin)+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	model := write("vmaf_v0.6.1.pkl", "(dp0\n")
+	write("vmaf_v0.6.1.pkl.model", "model\n")
+	manifest := write("master.m3u8", "#EXTM3U\n"+
+		"#EXT-X-STREAM-INF:BANDWIDTH=1000000,RESOLUTION=640x360\nlow.m3u8\n"+
+		"#EXT-X-STREAM-INF:BANDWIDTH=3000000,RESOLUTION=1280x720\nhigh.m3u8\n")
+	// users from 0-1, 1-2, 2-3, 3-4 and 4+ Mbps, watching at 640 and 1280 wide
+	data := write("data.json", `{"bandwidth_pcts":[0.1,0.2,0.2,0.2,0.3],"resolutions":[{"width":640,"pct":0.5},{"width":1280,"pct":0.5}]}`)
+
+	decoder := &fakeDecoder{
+		mezzanine: fakeProbe(1920, 1080, 300),
+		variants: map[string]*FFProbeOutput{
+			"low.m3u8":  fakeProbe(640, 360, 300),
+			"high.m3u8": fakeProbe(1280, 720, 300),
+		},
+		content: map[string]string{"mezzanine.mp4": "100", "low.m3u8": "60", "high.m3u8": "90"},
+	}
+	workDir := filepath.Join(dir, "work")
+	if err := os.MkdirAll(workDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	return AnalyzeConfig{
+		MezzanineFile:       filepath.Join(dir, "mezzanine.mp4"),
+		ManifestURL:         manifest,
+		DataFile:            data,
+		BandwidthBuckets:    5,
+		BandwidthBucketKbps: 1000,
+		Model:               model,
+		Threads:             1,
+		PoolMethod:          PoolHarmonicMean,
+		Decoder:             decoder,
+		DumpDir:             filepath.Join(dir, "dumps"),
+		WorkDir:             workDir,
+		RunID:               "test",
+	}, decoder
+}
+
+func TestAnalyzeWithFakeDecoder(t *testing.T) {
+	cfg, decoder := analyzeFixture(t)
+	report, err := Analyze(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decoder.dumped) != 2 {
+		t.Errorf("dumped %v, want both variants", decoder.dumped)
+	}
+	if len(report.Scores) != 4 {
+		t.Errorf("scored %d pairs, want both variants at both resolutions", len(report.Scores))
+	}
+	// 40% of users sustain the 60 VMAF variant and 50% the 90 one, 10% sustain neither
+	if report.AverageVMAF < 68.99 || report.AverageVMAF > 69.01 {
+		t.Errorf("average VMAF %f, want 69", report.AverageVMAF)
+	}
+}
+
+func TestAnalyzeVMAFOnlyOmitsSkippedMetrics(t *testing.T) {
+	cfg, _ := analyzeFixture(t)
+	cfg.ExtraMetrics = &ExtraMetrics{}
+	report, err := Analyze(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := json.Marshal(report.Scores)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var scores []map[string]interface{}
+	if err := json.Unmarshal(out, &scores); err != nil {
+		t.Fatal(err)
+	}
+	for _, score := range scores {
+		if _, ok := score["vmaf"]; !ok {
+			t.Errorf("score %v has no vmaf", score)
+		}
+		for _, skipped := range []string{"psnr", "ssim", "ms_ssim"} {
+			if _, ok := score[skipped]; ok {
+				t.Errorf("score %v reports the skipped %s", score, skipped)
+			}
+		}
+	}
+}
+
+func TestAnalyzeAbortReturnsPartialReport(t *testing.T) {
+	cfg, _ := analyzeFixture(t)
+	cfg.MinVMAF = 80
+	cfg.AbortOnFirstLow = true
+	report, err := Analyze(context.Background(), cfg)
+	lowScore, ok := err.(*LowScoreError)
+	if !ok || !lowScore.Aborted {
+		t.Fatalf("error %v, want an aborted *LowScoreError", err)
+	}
+	if report == nil {
+		t.Fatal("no report returned alongside the quality gate abort")
+	}
+	if len(report.Scores) == 0 || report.Scores[0].VMAF != 60 {
+		t.Errorf("scores %+v, want the low variant's score recorded before the abort", report.Scores)
+	}
+	if got := exitCode(err); got != exitFailure {
+		t.Errorf("exitCode = %d, want %d", got, exitFailure)
+	}
+}
+
+func TestAnalyzeFailsOnDecodeError(t *testing.T) {
+	cfg, decoder := analyzeFixture(t)
+	decoder.failDecode = "variant_1.ts"
+	report, err := Analyze(context.Background(), cfg)
+	if report != nil {
+		t.Errorf("got a report despite a failed decode")
+	}
+	if stageErr, ok := err.(*StageError); !ok || stageErr.Stage != StageVMAF {
+		t.Fatalf("error %v, want a %s *StageError", err, StageVMAF)
+	}
+	if !strings.Contains(err.Error(), "decoding") {
+		t.Errorf("error %q, want the decode's own error", err)
+	}
+}
+
+func TestAnalyzeLowVMAFThreshold(t *testing.T) {
+	cfg, _ := analyzeFixture(t)
+	cfg.LowVMAFThreshold = 70
+	report, err := Analyze(context.Background(), cfg)
+	if report != nil || err == nil || !strings.Contains(err.Error(), "--low-vmaf-threshold") {
+		t.Errorf("error %v, want the 60 VMAF variant to trip --low-vmaf-threshold 70", err)
+	}
+
+	cfg, _ = analyzeFixture(t)
+	cfg.LowVMAFThreshold = 0
+	if _, err := Analyze(context.Background(), cfg); err != nil {
+		t.Errorf("error %v with the threshold disabled", err)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// BDRateComparison is the Bjøntegaard delta rate of another ladder against this run's, the
+// average bitrate difference at equal VMAF over the VMAF range both ladders cover
+type BDRateComparison struct {
+	Ladder string `json:"ladder"`
+	// BDRate is the percentage change in bitrate of the other ladder, negative when it saves bits
+	BDRate  float64 `json:"bd_rate"`
+	MinVMAF float64 `json:"min_vmaf"`
+	MaxVMAF float64 `json:"max_vmaf"`
+}
+
+// rateCurve is a ladder's log10 bitrate as a function of VMAF, with VMAF strictly increasing
+type rateCurve struct {
+	vmaf, logRate []float64
+}
+
+func newRateCurve(points []ladderPoint) (*rateCurve, error) {
+	if len(points) < 2 {
+		return nil, fmt.Errorf("BD-rate needs at least 2 renditions scored at their native resolution, but there are %d", len(points))
+	}
+	sorted := append([]ladderPoint(nil), points...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].VMAF < sorted[j].VMAF })
+	curve := &rateCurve{}
+	for i, point := range sorted {
+		if i > 0 && point.VMAF <= sorted[i-1].VMAF {
+			return nil, fmt.Errorf("Renditions %d and %d both score %.3f VMAF, so bitrate isn't a function of quality", sorted[i-1].Variant, point.Variant, point.VMAF)
+		}
+		curve.vmaf = append(curve.vmaf, point.VMAF)
+		curve.logRate = append(curve.logRate, math.Log10(float64(point.Bandwidth)))
+	}
+	return curve, nil
+}
+
+// slopes are the monotone piecewise cubic Hermite (PCHIP) derivatives at each point, per
+// Fritsch and Carlson
+func (c *rateCurve) slopes() []float64 {
+	n := len(c.vmaf)
+	h := make([]float64, n-1)
+	delta := make([]float64, n-1)
+	for k := 0; k < n-1; k++ {
+		h[k] = c.vmaf[k+1] - c.vmaf[k]
+		delta[k] = (c.logRate[k+1] - c.logRate[k]) / h[k]
+	}
+	d := make([]float64, n)
+	if n == 2 {
+		d[0], d[1] = delta[0], delta[0]
+		return d
+	}
+	for k := 1; k < n-1; k++ {
+		if delta[k-1]*delta[k] <= 0 {
+			continue
+		}
+		w1, w2 := 2*h[k]+h[k-1], h[k]+2*h[k-1]
+		d[k] = (w1 + w2) / (w1/delta[k-1] + w2/delta[k])
+	}
+	d[0] = pchipEndSlope(h[0], h[1], delta[0], delta[1])
+	d[n-1] = pchipEndSlope(h[n-2], h[n-3], delta[n-2], delta[n-3])
+	return d
+}
+
+// pchipEndSlope is the shape preserving three point estimate of the slope at an end of the curve
+func pchipEndSlope(h0, h1, delta0, delta1 float64) float64 {
+	d := ((2*h0+h1)*delta0 - h0*delta1) / (h0 + h1)
+	if math.Signbit(d) != math.Signbit(delta0) {
+		return 0
+	}
+	if math.Signbit(delta0) != math.Signbit(delta1) && math.Abs(d) > math.Abs(3*delta0) {
+		return 3 * delta0
+	}
+	return d
+}
+
+// integrate is the exact integral of the curve's PCHIP interpolant over VMAF from lo to hi
+func (c *rateCurve) integrate(lo, hi float64) float64 {
+	d := c.slopes()
+	// antiderivatives of the Hermite basis functions
+	h00 := func(t float64) float64 { return t*t*t*t/2 - t*t*t + t }
+	h10 := func(t float64) float64 { return t*t*t*t/4 - 2*t*t*t/3 + t*t/2 }
+	h01 := func(t float64) float64 { return -t*t*t*t/2 + t*t*t }
+	h11 := func(t float64) float64 { return t*t*t*t/4 - t*t*t/3 }
+
+	total := 0.0
+	for k := 0; k < len(c.vmaf)-1; k++ {
+		x0, x1 := c.vmaf[k], c.vmaf[k+1]
+		a, b := math.Max(lo, x0), math.Min(hi, x1)
+		if a >= b {
+			continue
+		}
+		h := x1 - x0
+		t0, t1 := (a-x0)/h, (b-x0)/h
+		span := func(f func(float64) float64) float64 { return f(t1) - f(t0) }
+		total += h * (c.logRate[k]*span(h00) + h*d[k]*span(h10) + c.logRate[k+1]*span(h01) + h*d[k+1]*span(h11))
+	}
+	return total
+}
+
+// bdRate is the Bjøntegaard delta rate of test against anchor with VMAF as the quality axis,
+// integrating each ladder's piecewise cubic log bitrate over the VMAF range they share
+func bdRate(anchor, test []ladderPoint) (*BDRateComparison, error) {
+	anchorCurve, err := newRateCurve(anchor)
+	if err != nil {
+		return nil, err
+	}
+	testCurve, err := newRateCurve(test)
+	if err != nil {
+		return nil, err
+	}
+	lo := math.Max(anchorCurve.vmaf[0], testCurve.vmaf[0])
+	hi := math.Min(anchorCurve.vmaf[len(anchorCurve.vmaf)-1], testCurve.vmaf[len(testCurve.vmaf)-1])
+	if lo >= hi {
+		return nil, fmt.Errorf("The ladders' VMAF ranges don't overlap, so can't be compared at equal quality")
+	}
+	meanDiff := (testCurve.integrate(lo, hi) - anchorCurve.integrate(lo, hi)) / (hi - lo)
+	return &BDRateComparison{
+		BDRate:  (math.Pow(10, meanDiff) - 1) * 100,
+		MinVMAF: lo,
+		MaxVMAF: hi,
+	}, nil
+}
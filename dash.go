@@ -0,0 +1,378 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/grafov/m3u8"
+)
+
+// MPD is the subset of a DASH manifest needed to build SegmentTemplate URLs
+type MPD struct {
+	MediaPresentationDuration string        `xml:"mediaPresentationDuration,attr"`
+	BaseURL                   string        `xml:"BaseURL"`
+	Periods                   []*DASHPeriod `xml:"Period"`
+}
+
+type DASHPeriod struct {
+	ID             string               `xml:"id,attr"`
+	Start          string               `xml:"start,attr"`
+	Duration       string               `xml:"duration,attr"`
+	BaseURL        string               `xml:"BaseURL"`
+	AdaptationSets []*DASHAdaptationSet `xml:"AdaptationSet"`
+}
+
+type DASHAdaptationSet struct {
+	MimeType        string                `xml:"mimeType,attr"`
+	ContentType     string                `xml:"contentType,attr"`
+	BaseURL         string                `xml:"BaseURL"`
+	SegmentTemplate *DASHSegmentTemplate  `xml:"SegmentTemplate"`
+	Representations []*DASHRepresentation `xml:"Representation"`
+}
+
+type DASHRepresentation struct {
+	ID              string               `xml:"id,attr"`
+	Bandwidth       uint32               `xml:"bandwidth,attr"`
+	Width           uint64               `xml:"width,attr"`
+	Height          uint64               `xml:"height,attr"`
+	MimeType        string               `xml:"mimeType,attr"`
+	BaseURL         string               `xml:"BaseURL"`
+	SegmentTemplate *DASHSegmentTemplate `xml:"SegmentTemplate"`
+}
+
+type DASHSegmentTemplate struct {
+	Media                  string               `xml:"media,attr"`
+	Initialization         string               `xml:"initialization,attr"`
+	StartNumber            *uint64              `xml:"startNumber,attr"`
+	Timescale              *uint64              `xml:"timescale,attr"`
+	Duration               uint64               `xml:"duration,attr"`
+	PresentationTimeOffset uint64               `xml:"presentationTimeOffset,attr"`
+	SegmentTimeline        *DASHSegmentTimeline `xml:"SegmentTimeline"`
+}
+
+type DASHSegmentTimeline struct {
+	Segments []*DASHTimelineSegment `xml:"S"`
+}
+
+type DASHTimelineSegment struct {
+	T *uint64 `xml:"t,attr"`
+	D uint64  `xml:"d,attr"`
+	R int     `xml:"r,attr"`
+}
+
+// DASHSegment is a single media segment resolved from a SegmentTemplate
+type DASHSegment struct {
+	Number uint64
+	Time   uint64
+}
+
+var (
+	iso8601DurationRegex    = regexp.MustCompile(`^P(?:(\d+(?:\.\d+)?)D)?(?:T(?:(\d+(?:\.\d+)?)H)?(?:(\d+(?:\.\d+)?)M)?(?:(\d+(?:\.\d+)?)S)?)?$`)
+	templateIdentifierRegex = regexp.MustCompile(`\$([A-Za-z]*)(%0\d+d)?\$`)
+)
+
+// isDASHManifest reports whether the manifest URL points at a DASH MPD
+func isDASHManifest(manifestURL string) bool {
+	parsed, err := url.Parse(manifestURL)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(path.Ext(parsed.Path), ".mpd")
+}
+
+// parseISO8601Duration parses an xs:duration such as PT1H2M3.5S into seconds
+func parseISO8601Duration(in string) (float64, error) {
+	matches := iso8601DurationRegex.FindStringSubmatch(in)
+	if matches == nil || in == "P" || in == "PT" {
+		return 0, fmt.Errorf("invalid ISO 8601 duration %q", in)
+	}
+
+	seconds := 0.0
+	for i, unit := range []float64{86400, 3600, 60, 1} {
+		if matches[i+1] == "" {
+			continue
+		}
+		value, err := strconv.ParseFloat(matches[i+1], 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid ISO 8601 duration %q: %v", in, err)
+		}
+		seconds += value * unit
+	}
+	return seconds, nil
+}
+
+// periodDuration works out how long a period lasts, from its own duration, the start of
+// the following period or, for the final period, the presentation duration. It's 0 when unknown.
+func (m *MPD) periodDuration(index int) (float64, error) {
+	period := m.Periods[index]
+	if period.Duration != "" {
+		return parseISO8601Duration(period.Duration)
+	}
+
+	start := 0.0
+	if period.Start != "" {
+		var err error
+		if start, err = parseISO8601Duration(period.Start); err != nil {
+			return 0, err
+		}
+	}
+	if index+1 < len(m.Periods) && m.Periods[index+1].Start != "" {
+		next, err := parseISO8601Duration(m.Periods[index+1].Start)
+		if err != nil {
+			return 0, err
+		}
+		return next - start, nil
+	}
+	if m.MediaPresentationDuration != "" {
+		total, err := parseISO8601Duration(m.MediaPresentationDuration)
+		if err != nil {
+			return 0, err
+		}
+		return total - start, nil
+	}
+	return 0, nil
+}
+
+// expandTemplate substitutes the DASH template identifiers, erroring on any it doesn't know
+func expandTemplate(template string, rep *DASHRepresentation, segment DASHSegment) (string, error) {
+	var expandErr error
+	expanded := templateIdentifierRegex.ReplaceAllStringFunc(template, func(match string) string {
+		parts := templateIdentifierRegex.FindStringSubmatch(match)
+		identifier, format := parts[1], parts[2]
+		if format == "" {
+			format = "%d"
+		}
+
+		switch identifier {
+		case "":
+			return "$"
+		case "RepresentationID":
+			return rep.ID
+		case "Number":
+			return fmt.Sprintf(format, segment.Number)
+		case "Time":
+			return fmt.Sprintf(format, segment.Time)
+		case "Bandwidth":
+			return fmt.Sprintf(format, rep.Bandwidth)
+		}
+		expandErr = fmt.Errorf("unsupported SegmentTemplate identifier %q in %q", match, template)
+		return match
+	})
+	return expanded, expandErr
+}
+
+// templateSegments lists the segments a template resolves to within a period, failing when
+// an explicit timeline runs past the period's end
+func templateSegments(template *DASHSegmentTemplate, periodSeconds float64) ([]DASHSegment, error) {
+	timescale := uint64(1)
+	if template.Timescale != nil && *template.Timescale > 0 {
+		timescale = *template.Timescale
+	}
+	number := uint64(1)
+	if template.StartNumber != nil {
+		number = *template.StartNumber
+	}
+	// timeline times are offset by the presentation time offset from the start of the period
+	periodTicks := uint64(math.Round(periodSeconds*float64(timescale))) + template.PresentationTimeOffset
+
+	var segments []DASHSegment
+	if template.SegmentTimeline != nil {
+		now := template.PresentationTimeOffset
+		for i, s := range template.SegmentTimeline.Segments {
+			if s.T != nil {
+				now = *s.T
+			}
+			if s.D == 0 {
+				return nil, fmt.Errorf("SegmentTimeline entry %d has no duration", i)
+			}
+
+			repeats := s.R
+			if repeats < 0 {
+				// a negative repeat runs until the end of the period
+				if periodSeconds <= 0 || periodTicks <= now {
+					return nil, fmt.Errorf("SegmentTimeline entry %d repeats past the end of the period", i)
+				}
+				repeats = int((periodTicks-now+s.D-1)/s.D) - 1
+			}
+			for r := 0; r <= repeats; r++ {
+				segments = append(segments, DASHSegment{Number: number, Time: now})
+				number++
+				now += s.D
+			}
+		}
+
+		// allow the final segment to overhang the period by less than one segment
+		if periodSeconds > 0 && len(segments) > 0 && segments[len(segments)-1].Time >= periodTicks {
+			return nil, fmt.Errorf("SegmentTimeline runs past the %fs period", periodSeconds)
+		}
+		return segments, nil
+	}
+
+	if template.Duration == 0 {
+		return nil, fmt.Errorf("SegmentTemplate has neither a duration nor a SegmentTimeline")
+	}
+	if periodSeconds <= 0 {
+		return nil, fmt.Errorf("SegmentTemplate with a fixed duration needs a period duration")
+	}
+	count := (periodTicks - template.PresentationTimeOffset + template.Duration - 1) / template.Duration
+	for i := uint64(0); i < count; i++ {
+		segments = append(segments, DASHSegment{Number: number + i, Time: template.PresentationTimeOffset + i*template.Duration})
+	}
+	return segments, nil
+}
+
+// resolveBaseURLs applies each level of BaseURL in turn, starting from the manifest URL
+func resolveBaseURLs(manifestURL string, baseURLs ...string) (string, error) {
+	resolved := manifestURL
+	for _, baseURL := range baseURLs {
+		if baseURL == "" {
+			continue
+		}
+		var err error
+		if resolved, err = resolveURI(resolved, strings.TrimSpace(baseURL)); err != nil {
+			return "", err
+		}
+	}
+	return resolved, nil
+}
+
+// downloadInto appends the body of a URL onto w
+func downloadInto(ctx context.Context, w io.Writer, segmentURL string, fetcher *HTTPFetcher) error {
+	body, err := fetchURL(ctx, segmentURL, fetcher)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	_, err = io.Copy(w, body)
+	return err
+}
+
+// loadDASHVariants turns the video representations of the first period of an MPD into variants, downloading
+// the init segment plus up to maxSegments media segments of each into dir (0 downloads every segment)
+func loadDASHVariants(ctx context.Context, manifestURL string, fetcher *HTTPFetcher, maxSegments int, dir string) ([]*m3u8.Variant, error) {
+	body, err := fetchURL(ctx, manifestURL, fetcher)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	var mpd MPD
+	if err := xml.NewDecoder(body).Decode(&mpd); err != nil {
+		return nil, fmt.Errorf("Failed to decode DASH manifest: %v", err)
+	}
+	if len(mpd.Periods) == 0 {
+		return nil, fmt.Errorf("DASH manifest has no periods")
+	}
+	if len(mpd.Periods) > 1 {
+		slog.Warn("DASH manifest has several periods, only the first is analyzed", "periods", len(mpd.Periods))
+	}
+
+	period := mpd.Periods[0]
+	periodSeconds, err := mpd.periodDuration(0)
+	if err != nil {
+		return nil, err
+	}
+
+	var variants []*m3u8.Variant
+	for _, set := range period.AdaptationSets {
+		for _, rep := range set.Representations {
+			mimeType := rep.MimeType
+			if mimeType == "" {
+				mimeType = set.MimeType
+			}
+			if !strings.HasPrefix(mimeType, "video/") && set.ContentType != "video" {
+				continue
+			}
+
+			template := rep.SegmentTemplate
+			if template == nil {
+				template = set.SegmentTemplate
+			}
+			if template == nil || template.Media == "" {
+				return nil, fmt.Errorf("representation %q has no SegmentTemplate", rep.ID)
+			}
+
+			segments, err := templateSegments(template, periodSeconds)
+			if err != nil {
+				return nil, fmt.Errorf("representation %q: %v", rep.ID, err)
+			}
+			if maxSegments > 0 {
+				if maxSegments > len(segments) {
+					return nil, fmt.Errorf("representation %q only has %d segments, fewer than the %d requested", rep.ID, len(segments), maxSegments)
+				}
+				segments = segments[:maxSegments]
+			}
+
+			base, err := resolveBaseURLs(manifestURL, mpd.BaseURL, period.BaseURL, set.BaseURL, rep.BaseURL)
+			if err != nil {
+				return nil, err
+			}
+
+			localPath := filepath.Join(dir, fmt.Sprintf("dash_%s.mp4", rep.ID))
+			slog.Info("Downloading DASH representation", "representation", rep.ID, "segments", len(segments), "path", localPath)
+			if err := downloadRepresentation(ctx, localPath, base, rep, template, segments, fetcher); err != nil {
+				return nil, fmt.Errorf("representation %q: %v", rep.ID, err)
+			}
+
+			variants = append(variants, &m3u8.Variant{
+				URI: localPath,
+				VariantParams: m3u8.VariantParams{
+					Bandwidth:  rep.Bandwidth,
+					Resolution: fmt.Sprintf("%dx%d", rep.Width, rep.Height),
+					Name:       rep.ID,
+				},
+			})
+		}
+	}
+	if len(variants) == 0 {
+		return nil, fmt.Errorf("DASH manifest has no video representations")
+	}
+	return variants, nil
+}
+
+// downloadRepresentation concatenates the init segment and media segments into a single fragmented mp4
+func downloadRepresentation(ctx context.Context, localPath, base string, rep *DASHRepresentation, template *DASHSegmentTemplate, segments []DASHSegment, fetcher *HTTPFetcher) error {
+	f, err := os.Create(localPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var uris []string
+	if template.Initialization != "" {
+		uri, err := expandTemplate(template.Initialization, rep, DASHSegment{})
+		if err != nil {
+			return err
+		}
+		uris = append(uris, uri)
+	}
+	for _, segment := range segments {
+		uri, err := expandTemplate(template.Media, rep, segment)
+		if err != nil {
+			return err
+		}
+		uris = append(uris, uri)
+	}
+
+	for _, uri := range uris {
+		segmentURL, err := resolveURI(base, uri)
+		if err != nil {
+			return err
+		}
+		if err := downloadInto(ctx, f, segmentURL, fetcher); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// dataFileStdin is the --datafile that reads the data file from stdin
+const dataFileStdin = "-"
+
+// readDataFile reads a data file from stdin, an http(s) URL or a path on disk
+func readDataFile(ctx context.Context, location string, fetcher *HTTPFetcher) ([]byte, error) {
+	var r io.ReadCloser
+	switch {
+	case location == dataFileStdin:
+		r = ioutil.NopCloser(os.Stdin)
+	case strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://"):
+		body, err := fetchURL(ctx, location, fetcher)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to load data file: %v", err)
+		}
+		r = body
+	default:
+		f, err := os.Open(location)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to load data file: %v", err)
+		}
+		r = f
+	}
+	defer r.Close()
+
+	raw, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read data file: %v", err)
+	}
+	return raw, nil
+}
+
+// dataFileFields are the fields a data file may hold, at the top level or nested, to suggest the
+// intended one for a misspelled field
+var dataFileFields = []string{"resolution_pcts", "bandwidth_pcts", "view_time_pcts", "resolutions", "width", "pct"}
+
+// parseDataFile decodes a data file, rejecting fields it doesn't know and reporting the required
+// ones that are missing, rather than leaving a misspelled distribution empty
+func parseDataFile(raw []byte) (*DataFile, error) {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.DisallowUnknownFields()
+	var data DataFile
+	if err := decoder.Decode(&data); err != nil {
+		return nil, dataFileError(err)
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, fmt.Errorf("Data file has unexpected content after its JSON object")
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, dataFileError(err)
+	}
+	if _, ok := fields["bandwidth_pcts"]; !ok {
+		return nil, fmt.Errorf("Data file missing 'bandwidth_pcts'")
+	}
+	_, hasPcts := fields["resolution_pcts"]
+	_, hasResolutions := fields["resolutions"]
+	if !hasPcts && !hasResolutions {
+		return nil, fmt.Errorf("Data file missing 'resolution_pcts', or 'resolutions' listing widths")
+	}
+	if viewTime, ok := fields["view_time_pcts"]; ok {
+		var viewTimeFields map[string]json.RawMessage
+		if err := json.Unmarshal(viewTime, &viewTimeFields); err != nil {
+			return nil, dataFileError(err)
+		}
+		for _, name := range []string{"resolution_pcts", "bandwidth_pcts"} {
+			if _, ok := viewTimeFields[name]; !ok {
+				return nil, fmt.Errorf("Data file missing 'view_time_pcts.%s'", name)
+			}
+		}
+	}
+	return &data, nil
+}
+
+// dataFileError explains a decode error, naming the field a misspelled one most likely meant
+func dataFileError(err error) error {
+	const unknown = "json: unknown field "
+	msg := err.Error()
+	if !strings.HasPrefix(msg, unknown) {
+		return fmt.Errorf("Failed to unmarshal data: %v", err)
+	}
+	field := strings.Trim(strings.TrimPrefix(msg, unknown), `"`)
+	best, bestDistance := "", 3
+	for _, known := range dataFileFields {
+		if d := editDistance(field, known); d < bestDistance {
+			best, bestDistance = known, d
+		}
+	}
+	if best == "" {
+		return fmt.Errorf("Data file has unknown field '%s'", field)
+	}
+	return fmt.Errorf("Data file has unknown field '%s', did you mean '%s'?", field, best)
+}
+
+// editDistance is the Levenshtein distance between two strings
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j-1] + cost
+			if prev[j]+1 < cur[j] {
+				cur[j] = prev[j] + 1
+			}
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
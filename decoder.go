@@ -0,0 +1,18 @@
+package main
+
+import "context"
+
+// Decoder probes, dumps and decodes the mezzanine and ladder renditions.
+// FFMegDecoder is the ffmpeg backed implementation.
+type Decoder interface {
+	ProbeStreams(ctx context.Context, filename string) (*FFProbeOutput, error)
+	CountFrames(ctx context.Context, filename string) (uint64, error)
+	DumpStream(ctx context.Context, variantURL, outputName string) (*FFProbeOutput, error)
+	DecodeToWidthAndHeight(ctx context.Context, inputFile, outputFile string, width, height uint64) error
+	DecodeFramesToWidthAndHeight(ctx context.Context, inputFile, outputFile string, width, height, frames uint64) error
+	DecodeFrameRangeToWidthAndHeight(ctx context.Context, inputFile, outputFile string, width, height, start, end uint64) error
+	DecodeWindowToWidthAndHeight(ctx context.Context, inputFile, outputFile string, width, height uint64, seek float64, frames uint64) error
+	KeyframeTimes(ctx context.Context, filename string) ([]float64, error)
+	FrameHashes(ctx context.Context, filename string) ([]string, error)
+	FrameHashesAtWidthAndHeight(ctx context.Context, filename string, width, height, start, end uint64) ([]string, error)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gonum.org/v1/gonum/stat"
+)
+
+// defaultEfficiencyOutlier flags renditions less than half as efficient as their neighbors
+const defaultEfficiencyOutlier = "ratio:0.5"
+
+// EfficiencyRule decides which renditions spend too many bits for their VMAF
+type EfficiencyRule struct {
+	// ZScore flags renditions whose ratio to their neighbors stands out from the rest of the
+	// ladder's, rather than those below a fixed ratio
+	ZScore    bool
+	Threshold float64
+}
+
+// ParseEfficiencyRule validates an --efficiency-outlier value, ratio:X or zscore:X
+func ParseEfficiencyRule(in string) (*EfficiencyRule, error) {
+	name, value, ok := strings.Cut(in, ":")
+	threshold, err := strconv.ParseFloat(value, 64)
+	if !ok || err != nil || threshold <= 0 || (name != "ratio" && name != "zscore") {
+		return nil, fmt.Errorf("Invalid efficiency outlier rule %q, must be ratio:X or zscore:X with a positive X", in)
+	}
+	return &EfficiencyRule{ZScore: name == "zscore", Threshold: threshold}, nil
+}
+
+func (r *EfficiencyRule) String() string {
+	if r.ZScore {
+		return "zscore:" + strconv.FormatFloat(r.Threshold, 'f', -1, 64)
+	}
+	return "ratio:" + strconv.FormatFloat(r.Threshold, 'f', -1, 64)
+}
+
+// RenditionEfficiency is how much VMAF a rendition buys with its bitrate
+type RenditionEfficiency struct {
+	Variant     int     `json:"variant"`
+	Bandwidth   uint32  `json:"bandwidth"`
+	VMAF        float64 `json:"vmaf"`
+	VMAFPerMbps float64 `json:"vmaf_per_mbps"`
+	// NeighborRatio is the efficiency over the mean of the adjacent renditions'
+	NeighborRatio float64 `json:"neighbor_ratio,omitempty"`
+	// ZScore is how many standard deviations NeighborRatio is off the ladder's mean ratio
+	ZScore  float64 `json:"zscore,omitempty"`
+	Outlier bool    `json:"outlier,omitempty"`
+}
+
+// EfficiencyReport is the efficiency of each rendition scored at its native resolution
+type EfficiencyReport struct {
+	Rule       string                `json:"rule"`
+	Renditions []RenditionEfficiency `json:"renditions"`
+}
+
+// ladderEfficiency works out the efficiency of each point of a ladder sorted by bandwidth and
+// flags the outliers under rule
+func ladderEfficiency(curve []ladderPoint, rule *EfficiencyRule) *EfficiencyReport {
+	report := &EfficiencyReport{Rule: rule.String()}
+	for _, point := range curve {
+		if point.Bandwidth == 0 {
+			continue
+		}
+		report.Renditions = append(report.Renditions, RenditionEfficiency{
+			Variant:     point.Variant,
+			Bandwidth:   point.Bandwidth,
+			VMAF:        point.VMAF,
+			VMAFPerMbps: point.VMAF / (float64(point.Bandwidth) / 1e6),
+		})
+	}
+	renditions := report.Renditions
+
+	for i := range renditions {
+		var neighbors []float64
+		if i > 0 {
+			neighbors = append(neighbors, renditions[i-1].VMAFPerMbps)
+		}
+		if i+1 < len(renditions) {
+			neighbors = append(neighbors, renditions[i+1].VMAFPerMbps)
+		}
+		if len(neighbors) == 0 {
+			continue
+		}
+		if mean := stat.Mean(neighbors, nil); mean > 0 {
+			renditions[i].NeighborRatio = renditions[i].VMAFPerMbps / mean
+		}
+	}
+
+	var ratios []float64
+	for _, rendition := range renditions {
+		ratios = append(ratios, rendition.NeighborRatio)
+	}
+	if len(ratios) >= 3 {
+		mean, sd := stat.MeanStdDev(ratios, nil)
+		for i := range renditions {
+			if sd > 0 {
+				renditions[i].ZScore = (ratios[i] - mean) / sd
+			}
+		}
+	}
+
+	for i := range renditions {
+		rendition := &renditions[i]
+		switch {
+		case rendition.VMAFPerMbps == 0:
+			rendition.Outlier = len(renditions) > 1
+		case rule.ZScore:
+			rendition.Outlier = rendition.ZScore < -rule.Threshold
+		default:
+			rendition.Outlier = rendition.NeighborRatio > 0 && rendition.NeighborRatio < rule.Threshold
+		}
+	}
+	return report
+}
@@ -4,7 +4,14 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
 	"os/exec"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type FFProbeOutput struct {
@@ -13,28 +20,292 @@ type FFProbeOutput struct {
 }
 
 type FFProbeStream struct {
-	Width    uint64 `json:"width"`
-	Height   uint64 `json:"height"`
-	NbFrames uint64 `json:"nb_frames,string"`
+	CodecName     string       `json:"codec_name"`
+	ColorTransfer string       `json:"color_transfer"`
+	Width         uint64       `json:"width"`
+	Height        uint64       `json:"height"`
+	NbFrames      ffprobeCount `json:"nb_frames"`
+	NbReadFrames  ffprobeCount `json:"nb_read_frames"`
+	BitRate       ffprobeCount `json:"bit_rate"`
+	AvgFrameRate  string       `json:"avg_frame_rate"`
+	Duration      string       `json:"duration"`
+	// SampleAspectRatio is the shape of the pixels as num:den, anamorphic sources have non-square ones
+	SampleAspectRatio  string `json:"sample_aspect_ratio"`
+	DisplayAspectRatio string `json:"display_aspect_ratio"`
+	// PixFmt is the stream's pixel format, such as yuv420p10le for 10-bit 4:2:0
+	PixFmt string `json:"pix_fmt"`
+	// RFrameRate is the stream's base frame rate, which unlike avg_frame_rate isn't skewed by
+	// the odd dropped frame
+	RFrameRate string `json:"r_frame_rate"`
+	// Profile and Level are the encode's, such as High and 41 for h264. ffprobe reports a level of
+	// -99 when it isn't known.
+	Profile string `json:"profile"`
+	Level   int    `json:"level"`
+	// StartTime is the seconds the stream's first timestamp is at, which MPEG-TS rarely starts at 0
+	StartTime string `json:"start_time"`
+	// StartPts is the same in TimeBase units, the num/den seconds a timestamp counts
+	StartPts int64  `json:"start_pts"`
+	TimeBase string `json:"time_base"`
 }
 
+// parseRatio parses an ffprobe num:den ratio, returning 0 when it's unknown
+func parseRatio(ratio string) float64 {
+	parts := strings.Split(ratio, ":")
+	if len(parts) != 2 {
+		return 0
+	}
+	num, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil {
+		return 0
+	}
+	den, err := strconv.ParseFloat(parts[1], 64)
+	if err != nil || den == 0 {
+		return 0
+	}
+	return num / den
+}
+
+// PixelAspectRatio is the width to height ratio of the stream's pixels, worked out from the display
+// aspect ratio when ffprobe doesn't report one. Pixels of unknown shape are assumed to be square.
+func (s *FFProbeStream) PixelAspectRatio() float64 {
+	if sar := parseRatio(s.SampleAspectRatio); sar > 0 {
+		return sar
+	}
+	if dar := parseRatio(s.DisplayAspectRatio); dar > 0 && s.Width > 0 {
+		return dar * float64(s.Height) / float64(s.Width)
+	}
+	return 1
+}
+
+// LevelName is the stream's level as written in codec specs, 4.1 rather than ffprobe's 41 for h264
+// or 123 for hevc. It's empty when the level isn't known.
+func (s *FFProbeStream) LevelName() string {
+	if s.Level <= 0 {
+		return ""
+	}
+	switch s.CodecName {
+	case "h264":
+		return strconv.FormatFloat(float64(s.Level)/10, 'f', -1, 64)
+	case "hevc":
+		return strconv.FormatFloat(float64(s.Level)/30, 'f', -1, 64)
+	}
+	return strconv.Itoa(s.Level)
+}
+
+// ffprobeCount is a count ffprobe reports as a string, which is "N/A" when the container doesn't
+// know it, for example in live MPEG-TS. Unknown counts unmarshal to 0.
+type ffprobeCount uint64
+
+func (c *ffprobeCount) UnmarshalJSON(data []byte) error {
+	s := strings.Trim(string(data), `"`)
+	if s == "" || s == "N/A" {
+		*c = 0
+		return nil
+	}
+	n, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return fmt.Errorf("Invalid ffprobe count %s: %v", data, err)
+	}
+	*c = ffprobeCount(n)
+	return nil
+}
+
+// FrameRate parses the stream's num/den average frame rate, returning 0 when it's unknown
+func (s *FFProbeStream) FrameRate() float64 {
+	return parseFrameRate(s.AvgFrameRate)
+}
+
+// SameFrameRate reports whether both streams run at the same frame rate, comparing the average
+// and base frame rates in turn. true when neither can be compared.
+func (s *FFProbeStream) SameFrameRate(other *FFProbeStream) bool {
+	compared := false
+	for _, rates := range [][2]string{{s.AvgFrameRate, other.AvgFrameRate}, {s.RFrameRate, other.RFrameRate}} {
+		a, b := parseFrameRate(rates[0]), parseFrameRate(rates[1])
+		if a <= 0 || b <= 0 {
+			continue
+		}
+		if math.Abs(a-b) <= frameRateTolerance {
+			return true
+		}
+		compared = true
+	}
+	return !compared
+}
+
+// parseFrameRate parses an ffprobe num/den frame rate, returning 0 when it's unknown
+func parseFrameRate(rate string) float64 {
+	parts := strings.Split(rate, "/")
+	num, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil {
+		return 0
+	}
+	if len(parts) == 1 {
+		return num
+	}
+	den, err := strconv.ParseFloat(parts[1], 64)
+	if err != nil || den == 0 {
+		return 0
+	}
+	return num / den
+}
+
+// FFProbeFrame carries a frame's timestamps, ffprobe 5.x dropped pkt_pts in favour of pts
 type FFProbeFrame struct {
-	PktPts int64 `json:"pkt_pts"`
+	PktPts     int64  `json:"pkt_pts"`
+	PktPtsTime string `json:"pkt_pts_time"`
+	Pts        int64  `json:"pts"`
+	PtsTime    string `json:"pts_time"`
+	PktDts     int64  `json:"pkt_dts"`
+	PktDtsTime string `json:"pkt_dts_time"`
+}
+
+// Timestamp returns the frame's presentation timestamp in stream time base units,
+// falling back from pts to pkt_pts to pkt_dts. A timestamp only counts as present
+// when its _time counterpart is, as a missing one unmarshals to 0.
+func (f *FFProbeFrame) Timestamp() (int64, bool) {
+	switch {
+	case f.PtsTime != "" && f.PtsTime != "N/A":
+		return f.Pts, true
+	case f.PktPtsTime != "" && f.PktPtsTime != "N/A":
+		return f.PktPts, true
+	case f.PktDtsTime != "" && f.PktDtsTime != "N/A":
+		return f.PktDts, true
+	}
+	return 0, false
+}
+
+// FrameCount returns the number of video frames found while probing, preferring
+// the enumerated frame list and falling back to ffprobe's nb_read_frames count.
+func (p *FFProbeOutput) FrameCount() uint64 {
+	if len(p.Frames) > 0 || len(p.Streams) == 0 {
+		return uint64(len(p.Frames))
+	}
+	return uint64(p.Streams[0].NbReadFrames)
+}
+
+// Duration returns the video stream's duration in seconds, working it out from the frame count
+// and frame rate when ffprobe doesn't report one
+func (p *FFProbeOutput) Duration() float64 {
+	if len(p.Streams) == 0 {
+		return 0
+	}
+	if duration, err := strconv.ParseFloat(p.Streams[0].Duration, 64); err == nil {
+		return duration
+	}
+	if fps := p.Streams[0].FrameRate(); fps > 0 {
+		return float64(p.FrameCount()) / fps
+	}
+	return 0
 }
 
 type FFMegDecoder struct {
-	Filename string
+	Filename    string
+	FFmpegPath  string
+	FFprobePath string
+	Headers     http.Header
+	Limits      *ProcessLimits
+	// Netrc adds the Authorization of the host a dump reads from, nil when no netrc file is used
+	Netrc *NetrcAuth
+
+	// HTTPRetries and HTTPTimeout apply to dumps of http(s) variants
+	HTTPRetries int
+	HTTPTimeout time.Duration
+
+	// Cookies are passed to ffmpeg's -cookies, one Set-Cookie style cookie per entry
+	Cookies []string
+
+	// PixelFormat is the raw format frames are decoded to, yuv420p when empty
+	PixelFormat string
+	// Scaler is the scale filter's algorithm, the same for every input so scaling doesn't bias
+	// scores. DefaultScaler when empty.
+	Scaler string
+	// FrameSelect is a select filter expression on the input's frame numbers decodes only keep the
+	// frames of, all of them when empty
+	FrameSelect string
+
+	// PipeSize is the buffer size in bytes decodes resize their output FIFO to, 0 leaves it alone
+	PipeSize int
+
+	// MezzanineFile is probed and decoded from its VideoStreamIndex'th video stream, every
+	// other input from its first
+	MezzanineFile    string
+	VideoStreamIndex int
 }
 
 func NewFFmpegDecoder() *FFMegDecoder {
-	return &FFMegDecoder{}
+	return &FFMegDecoder{
+		FFmpegPath:  "ffmpeg",
+		FFprobePath: "ffprobe",
+	}
+}
+
+// DefaultScaler is the scaling algorithm decodes are resized with unless --scaler picks another
+const DefaultScaler = "bicubic"
+
+// scalers are the ffmpeg swscale algorithms --scaler accepts
+var scalers = []string{"fast_bilinear", "bilinear", "bicubic", "experimental", "neighbor", "area", "bicublin", "gauss", "sinc", "lanczos", "spline"}
+
+// ParseScaler validates a --scaler value
+func ParseScaler(in string) (string, error) {
+	for _, scaler := range scalers {
+		if in == scaler {
+			return scaler, nil
+		}
+	}
+	return "", fmt.Errorf("Unknown scaler %q, must be one of %s", in, strings.Join(scalers, ", "))
+}
+
+// scaleFilter resizes to widthxheight with the decoder's scaler
+func (f *FFMegDecoder) scaleFilter(width, height uint64) string {
+	scaler := f.Scaler
+	if scaler == "" {
+		scaler = DefaultScaler
+	}
+	return fmt.Sprintf("scale=%d:%d:flags=%s", width, height, scaler)
+}
+
+// pixFmt is the -pix_fmt decodes are converted to
+func (f *FFMegDecoder) pixFmt() string {
+	if f.PixelFormat == "" {
+		return DefaultPixelFormat.Name
+	}
+	return f.PixelFormat
+}
+
+// videoStream is the index of the video stream used from filename
+func (f *FFMegDecoder) videoStream(filename string) int {
+	if f.MezzanineFile != "" && filename == f.MezzanineFile {
+		return f.VideoStreamIndex
+	}
+	return 0
+}
+
+// ProbeStreams probes the video stream and counts its frames with -count_frames rather than
+// listing them, which is far less JSON for a long input and also works for the codecs and
+// containers -show_frames lists nothing for
+func (f *FFMegDecoder) ProbeStreams(ctx context.Context, filename string) (*FFProbeOutput, error) {
+	probe, err := f.probe(ctx, filename, "-show_streams")
+	if err != nil {
+		return nil, err
+	}
+	if len(probe.Streams) > 0 {
+		count, err := f.CountFrames(ctx, filename)
+		if err != nil {
+			return nil, err
+		}
+		probe.Streams[0].NbReadFrames = ffprobeCount(count)
+	}
+	return probe, nil
 }
 
-func (f *FFMegDecoder) ProbeFile(ctx context.Context, filename string) (*FFProbeOutput, error) {
-	probecmd := exec.CommandContext(ctx, "ffprobe", "-print_format", "json", "-show_streams", "-show_frames", "-select_streams", "v:0", filename)
-	stdoutData, err := probecmd.Output()
+func (f *FFMegDecoder) probe(ctx context.Context, filename string, show ...string) (*FFProbeOutput, error) {
+	selector := fmt.Sprintf("v:%d", f.videoStream(filename))
+	args := append([]string{"-print_format", "json"}, show...)
+	args = append(args, "-select_streams", selector, filename)
+	probecmd := exec.CommandContext(ctx, f.FFprobePath, args...)
+	stdoutData, err := runCommand(probecmd, f.Limits)
 	if err != nil {
-		fmt.Printf("Probe output: %s\n", string(stdoutData))
+		slog.Debug("Probe output", "output", string(stdoutData))
 		if exitErr, ok := err.(*exec.ExitError); ok {
 			return nil, fmt.Errorf("Error running probe: %s", exitErr.Stderr)
 		}
@@ -44,31 +315,231 @@ func (f *FFMegDecoder) ProbeFile(ctx context.Context, filename string) (*FFProbe
 	var probe FFProbeOutput
 	err = json.Unmarshal(stdoutData, &probe)
 	if err != nil {
-		fmt.Printf("Failed to unmarshal probe response: '%v'\n", err)
 		return nil, fmt.Errorf("Failed to unmarshal probe response: '%v'", err)
 	}
-
 	return &probe, nil
 }
 
+// CountFrames has ffprobe count the frames of the video stream, without listing them
+func (f *FFMegDecoder) CountFrames(ctx context.Context, filename string) (uint64, error) {
+	selector := fmt.Sprintf("v:%d", f.videoStream(filename))
+	countCmd := exec.CommandContext(ctx, f.FFprobePath, "-print_format", "json", "-count_frames", "-show_entries", "stream=nb_read_frames", "-select_streams", selector, filename)
+	stdoutData, err := runCommand(countCmd, f.Limits)
+	if err != nil {
+		slog.Debug("Count frames output", "output", string(stdoutData))
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return 0, fmt.Errorf("Error running frame count probe: %s", exitErr.Stderr)
+		}
+		return 0, fmt.Errorf("Unexpected error running frame count probe: %v", err)
+	}
+
+	var probe FFProbeOutput
+	if err := json.Unmarshal(stdoutData, &probe); err != nil {
+		return 0, fmt.Errorf("Failed to unmarshal frame count response: '%v'", err)
+	}
+	if len(probe.Streams) == 0 {
+		return 0, fmt.Errorf("Frame count probe returned no video stream")
+	}
+	return uint64(probe.Streams[0].NbReadFrames), nil
+}
+
 func (f *FFMegDecoder) DumpStream(ctx context.Context, variantURL, outputName string) (*FFProbeOutput, error) {
-	dumpCmd := exec.CommandContext(ctx, "ffmpeg", "-y", "-i", variantURL, "-c", "copy", outputName)
-	stdoutData, err := dumpCmd.Output()
+	// ffmpeg sends -headers to every host it reads from, so only the variant host's credentials go in
+	headers, err := f.Netrc.apply(f.Headers, variantURL)
+	if err != nil {
+		return nil, err
+	}
+	args := []string{"-y"}
+	if len(headers) > 0 {
+		args = append(args, "-headers", headerArg(headers))
+	}
+	if len(f.Cookies) > 0 {
+		args = append(args, "-cookies", strings.Join(f.Cookies, "\n"))
+	}
+	if f.HTTPTimeout > 0 {
+		args = append(args, "-rw_timeout", fmt.Sprintf("%d", f.HTTPTimeout/time.Microsecond))
+	}
+	// only the video is kept, audio in muxed segments would otherwise be counted with its frames
+	args = append(args, "-i", variantURL, "-map", f.streamMap(variantURL), "-c:v", "copy", outputName)
+
+	// only remote inputs can fail transiently
+	retries := 0
+	if strings.HasPrefix(variantURL, "http://") || strings.HasPrefix(variantURL, "https://") {
+		retries = f.HTTPRetries
+	}
+	err = retryWithBackoff(ctx, retries, func() (bool, error) {
+		dumpCmd := exec.CommandContext(ctx, f.FFmpegPath, args...)
+		stdoutData, err := runCommand(dumpCmd, f.Limits)
+		if err != nil {
+			slog.Debug("Dump output", "output", string(stdoutData))
+			if exitErr, ok := err.(*exec.ExitError); ok {
+				// ffmpeg reports HTTP errors as "Server returned 404 Not Found", client errors won't go away
+				clientErr := strings.Contains(string(exitErr.Stderr), "Server returned 4")
+				return !clientErr, fmt.Errorf("Error running ffmpeg dump: %s", exitErr.Stderr)
+			}
+			return false, fmt.Errorf("Unexpected error running ffmpeg dump: %v", err)
+		}
+		return false, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return f.ProbeStreams(ctx, outputName)
+}
+
+// KeyframeTimes lists the seconds from the start of the video stream each of its keyframes is at,
+// only decoding the keyframes to find them
+func (f *FFMegDecoder) KeyframeTimes(ctx context.Context, filename string) ([]float64, error) {
+	probe, err := f.probe(ctx, filename, "-skip_frame", "nokey", "-show_streams", "-show_frames")
+	if err != nil {
+		return nil, err
+	}
+	if len(probe.Streams) == 0 {
+		return nil, fmt.Errorf("Keyframe probe returned no video stream")
+	}
+	stream := probe.Streams[0]
+	// a time base is a num/den like a frame rate
+	timeBase := parseFrameRate(stream.TimeBase)
+	if timeBase == 0 {
+		return nil, fmt.Errorf("Keyframe probe returned no time base for the video stream")
+	}
+	var times []float64
+	for _, frame := range probe.Frames {
+		if timestamp, ok := frame.Timestamp(); ok {
+			times = append(times, float64(timestamp-stream.StartPts)*timeBase)
+		}
+	}
+	return times, nil
+}
+
+// FrameHashes returns the md5 of every decoded video frame in the file, in presentation order
+func (f *FFMegDecoder) FrameHashes(ctx context.Context, filename string) ([]string, error) {
+	return f.hashFrames(ctx, "-i", filename, "-map", f.streamMap(filename), "-f", "framemd5", "-")
+}
+
+// FrameHashesAtWidthAndHeight hashes frames [start, end) as DecodeFrameRangeToWidthAndHeight
+// decodes them, only the selected ones with a FrameSelect
+func (f *FFMegDecoder) FrameHashesAtWidthAndHeight(ctx context.Context, filename string, width, height, start, end uint64) ([]string, error) {
+	return f.hashFrames(ctx, append(f.frameRangeArgs(filename, width, height, start, end), "-f", "framemd5", "-")...)
+}
+
+func (f *FFMegDecoder) hashFrames(ctx context.Context, args ...string) ([]string, error) {
+	hashCmd := exec.CommandContext(ctx, f.FFmpegPath, args...)
+	stdoutData, err := runCommand(hashCmd, f.Limits)
 	if err != nil {
-		fmt.Printf("Dump output: %s\n", string(stdoutData))
 		if exitErr, ok := err.(*exec.ExitError); ok {
-			return nil, fmt.Errorf("Error running ffmpeg dump: %s", exitErr.Stderr)
+			return nil, fmt.Errorf("Error running ffmpeg frame hash: %s", exitErr.Stderr)
 		}
-		return nil, fmt.Errorf("Unexpected error running ffmpeg dump: %v", err)
+		return nil, fmt.Errorf("Unexpected error running ffmpeg frame hash: %v", err)
 	}
-	return f.ProbeFile(ctx, outputName)
+
+	// framemd5 lines are "stream, dts, pts, duration, size, hash" with '#' header comments
+	var hashes []string
+	for _, line := range strings.Split(string(stdoutData), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ",")
+		hashes = append(hashes, strings.TrimSpace(fields[len(fields)-1]))
+	}
+	return hashes, nil
+}
+
+// isFifo reports whether path is a named pipe rather than a regular file
+func isFifo(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode()&os.ModeNamedPipe != 0
+}
+
+// streamMap is the -map selector of the video stream used from filename
+func (f *FFMegDecoder) streamMap(filename string) string {
+	return fmt.Sprintf("0:v:%d", f.videoStream(filename))
+}
+
+// headerArg renders HTTP headers in the CRLF-separated form ffmpeg's -headers expects
+func headerArg(headers http.Header) string {
+	var sb strings.Builder
+	for key, values := range headers {
+		for _, value := range values {
+			sb.WriteString(key + ": " + value + "\r\n")
+		}
+	}
+	return sb.String()
 }
 
 func (f *FFMegDecoder) DecodeToWidthAndHeight(ctx context.Context, inputFile, outputFile string, width, height uint64) error {
-	decodeCmd := exec.CommandContext(ctx, "ffmpeg", "-y", "-i", inputFile, "-vf", fmt.Sprintf("scale=%d:%d", width, height), "-pix_fmt", "yuv420p", outputFile)
-	stdoutData, err := decodeCmd.Output()
+	return f.DecodeFramesToWidthAndHeight(ctx, inputFile, outputFile, width, height, 0)
+}
+
+// DecodeFramesToWidthAndHeight decodes at most frames frames of the input, or all of them when frames is 0
+func (f *FFMegDecoder) DecodeFramesToWidthAndHeight(ctx context.Context, inputFile, outputFile string, width, height, frames uint64) error {
+	return f.DecodeFrameRangeToWidthAndHeight(ctx, inputFile, outputFile, width, height, 0, frames)
+}
+
+// DecodeFrameRangeToWidthAndHeight decodes frames [start, end) of the input, with an end of 0 decoding
+// through to the last frame
+func (f *FFMegDecoder) DecodeFrameRangeToWidthAndHeight(ctx context.Context, inputFile, outputFile string, width, height, start, end uint64) error {
+	return f.decode(ctx, outputFile, append([]string{"-y"}, f.frameRangeArgs(inputFile, width, height, start, end)...))
+}
+
+// frameRangeArgs are the input and filter arguments that keep frames [start, end) of the input
+func (f *FFMegDecoder) frameRangeArgs(inputFile string, width, height, start, end uint64) []string {
+	filter := f.scaleFilter(width, height)
+	if f.FrameSelect != "" {
+		// selected by the input's own frame numbers and renumbered, so the rawvideo output doesn't
+		// duplicate frames into the gaps
+		frames := fmt.Sprintf("gte(n,%d)", start)
+		if end > 0 {
+			frames = fmt.Sprintf("between(n,%d,%d)", start, end-1)
+		}
+		filter = fmt.Sprintf("select='%s*(%s)',setpts=N/FRAME_RATE/TB,%s", frames, f.FrameSelect, filter)
+		return []string{"-i", inputFile, "-map", f.streamMap(inputFile), "-vf", filter, "-pix_fmt", f.pixFmt()}
+	}
+	if start > 0 {
+		trim := fmt.Sprintf("trim=start_frame=%d", start)
+		if end > 0 {
+			trim += fmt.Sprintf(":end_frame=%d", end)
+		}
+		filter = trim + ",setpts=PTS-STARTPTS," + filter
+	}
+	args := []string{"-i", inputFile, "-map", f.streamMap(inputFile), "-vf", filter, "-pix_fmt", f.pixFmt()}
+	if start == 0 && end > 0 {
+		args = append(args, "-frames:v", fmt.Sprintf("%d", end))
+	}
+	return args
+}
+
+// DecodeWindowToWidthAndHeight seeks to seek seconds from the start of the input and decodes frames
+// frames from there. ffmpeg decodes from the keyframe before seek and drops the frames ahead of it, so
+// the window can start anywhere but starts fastest on a keyframe.
+func (f *FFMegDecoder) DecodeWindowToWidthAndHeight(ctx context.Context, inputFile, outputFile string, width, height uint64, seek float64, frames uint64) error {
+	args := []string{"-y", "-ss", strconv.FormatFloat(seek, 'f', 6, 64), "-i", inputFile, "-map", f.streamMap(inputFile), "-vf", f.scaleFilter(width, height), "-pix_fmt", f.pixFmt(), "-frames:v", fmt.Sprintf("%d", frames)}
+	return f.decode(ctx, outputFile, args)
+}
+
+// decode runs an ffmpeg decode writing raw frames to outputFile. The format is always given, as
+// ffmpeg can't tell it from a FIFO or a temporary file's name.
+func (f *FFMegDecoder) decode(ctx context.Context, outputFile string, args []string) error {
+	// hand ffmpeg the already resized write end of the FIFO as fd 3
+	var fifo *os.File
+	if f.PipeSize > 0 && isFifo(outputFile) {
+		var err error
+		if fifo, err = openFifoWriter(ctx, outputFile, f.PipeSize); err != nil {
+			return fmt.Errorf("Error opening decode output %q: %v", outputFile, err)
+		}
+		defer fifo.Close()
+		args = append(args, "-f", "rawvideo", "pipe:3")
+	} else {
+		args = append(args, "-f", "rawvideo", outputFile)
+	}
+	decodeCmd := exec.CommandContext(ctx, f.FFmpegPath, args...)
+	if fifo != nil {
+		decodeCmd.ExtraFiles = []*os.File{fifo}
+	}
+	stdoutData, err := runCommand(decodeCmd, f.Limits)
 	if err != nil {
-		fmt.Printf("Decode output: %s\n", string(stdoutData))
+		slog.Debug("Decode output", "output", string(stdoutData))
 		if exitErr, ok := err.(*exec.ExitError); ok {
 			return fmt.Errorf("Error running ffmpeg decode: %s", exitErr.Stderr)
 		}
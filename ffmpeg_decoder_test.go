@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"path/filepath"
+	"testing"
+)
+
+// fakeFFprobe stands in for an ffprobe whose -show_streams lists no frames, only counting them
+// with -count_frames
+const fakeFFprobe = `for arg in "$@"; do
+	if [ "$arg" = "-count_frames" ]; then
+		echo '{"streams":[{"nb_read_frames":"120"}]}'
+		exit 0
+	fi
+done
+echo '{"frames":[],"streams":[{"width":1920,"height":1080,"nb_frames":"N/A","avg_frame_rate":"30/1"}]}'
+`
+
+func TestProbeStreamsCountsFrames(t *testing.T) {
+	f := NewFFmpegDecoder()
+	f.FFprobePath = fakeBinary(t, "ffprobe", fakeFFprobe)
+
+	probe, err := f.ProbeStreams(context.Background(), "mezzanine.mkv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := probe.FrameCount(); got != 120 {
+		t.Errorf("FrameCount() = %d, want the 120 frames -count_frames read", got)
+	}
+	if stream := probe.Streams[0]; stream.Width != 1920 || stream.Height != 1080 {
+		t.Errorf("stream is %dx%d, want 1920x1080", stream.Width, stream.Height)
+	}
+}
+
+func TestFFProbeFrameTimestamp(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame string
+		want  int64
+		ok    bool
+	}{
+		{"ffprobe 4.x", `{"pkt_pts":3003,"pkt_pts_time":"0.033367","pkt_dts":0,"pkt_dts_time":"0.000000"}`, 3003, true},
+		{"ffprobe 5.x", `{"pts":3003,"pts_time":"0.033367","pkt_dts":0,"pkt_dts_time":"0.000000"}`, 3003, true},
+		{"ffprobe 5.x at 0", `{"pts":0,"pts_time":"0.000000","pkt_dts":-3003,"pkt_dts_time":"-0.033367"}`, 0, true},
+		{"dts only", `{"pts_time":"N/A","pkt_dts":6006,"pkt_dts_time":"0.066733"}`, 6006, true},
+		{"no timestamps", `{"pts_time":"N/A","pkt_dts_time":"N/A"}`, 0, false},
+	}
+	for _, test := range tests {
+		var frame FFProbeFrame
+		if err := json.Unmarshal([]byte(test.frame), &frame); err != nil {
+			t.Fatal(err)
+		}
+		got, ok := frame.Timestamp()
+		if got != test.want || ok != test.ok {
+			t.Errorf("%s: Timestamp() = %d, %v, want %d, %v", test.name, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestKeyframeTimesFromTimestamps(t *testing.T) {
+	// an MPEG-TS stream starting 1.4s in, keyframes every 2s, as ffprobe 5.x lists them
+	probe := `{"streams":[{"time_base":"1/90000","start_pts":126000,"start_time":"1.400000"}],"frames":[` +
+		`{"pts":126000,"pts_time":"1.400000"},{"pts":306000,"pts_time":"3.400000"},{"pts":486000,"pts_time":"5.400000"}]}`
+	f := NewFFmpegDecoder()
+	f.FFprobePath = fakeBinary(t, "ffprobe", "echo '"+probe+"'\n")
+
+	times, err := f.KeyframeTimes(context.Background(), "mezzanine.ts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []float64{0, 2, 4}
+	if len(times) != len(want) {
+		t.Fatalf("keyframe times %v, want %v", times, want)
+	}
+	for i := range want {
+		if math.Abs(times[i]-want[i]) > 1e-9 {
+			t.Errorf("keyframe %d at %fs, want %fs", i, times[i], want[i])
+		}
+	}
+}
+
+func TestFFProbeCountNotAvailable(t *testing.T) {
+	var probe FFProbeOutput
+	fixture := `{"streams":[{"width":1280,"height":720,"nb_frames":"N/A","bit_rate":"N/A","nb_read_frames":"250"}]}`
+	if err := json.Unmarshal([]byte(fixture), &probe); err != nil {
+		t.Fatalf("unmarshalling nb_frames N/A: %v", err)
+	}
+	stream := probe.Streams[0]
+	if stream.NbFrames != 0 || stream.BitRate != 0 {
+		t.Errorf("nb_frames %d and bit_rate %d, want N/A to be 0", stream.NbFrames, stream.BitRate)
+	}
+	if got := probe.FrameCount(); got != 250 {
+		t.Errorf("FrameCount() = %d, want the 250 counted frames", got)
+	}
+}
+
+func TestDumpStreamMapsOnlyTheVideo(t *testing.T) {
+	argsFile := filepath.Join(t.TempDir(), "args")
+	t.Setenv("ARGS_FILE", argsFile)
+	f := NewFFmpegDecoder()
+	f.FFmpegPath = fakeBinary(t, "ffmpeg", recordArgs)
+	f.FFprobePath = fakeBinary(t, "ffprobe", fakeFFprobe)
+	// the mezzanine's stream index doesn't apply to the variants
+	f.MezzanineFile, f.VideoStreamIndex = "mezzanine.mp4", 2
+
+	output := filepath.Join(t.TempDir(), "variant_0.ts")
+	if _, err := f.DumpStream(context.Background(), "https://cdn.example.com/muxed.m3u8", output); err != nil {
+		t.Fatal(err)
+	}
+	args := recordedArgs(t, argsFile)
+	if got := argAfter(args, "-map"); got != "0:v:0" {
+		t.Errorf("-map %q, want only the variant's first video stream", got)
+	}
+	if got := argAfter(args, "-c:v"); got != "copy" {
+		t.Errorf("-c:v %q, want the video copied", got)
+	}
+	for _, arg := range args {
+		if arg == "-c" || arg == "-c:a" {
+			t.Errorf("args %v copy streams besides the video", args)
+		}
+	}
+}
+
+func TestScaleFilterUsesTheScaler(t *testing.T) {
+	for _, scaler := range scalers {
+		t.Run(scaler, func(t *testing.T) {
+			parsed, err := ParseScaler(scaler)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f := NewFFmpegDecoder()
+			f.Scaler = parsed
+			if got, want := f.scaleFilter(1280, 720), "scale=1280:720:flags="+scaler; got != want {
+				t.Errorf("scaleFilter = %q, want %q", got, want)
+			}
+		})
+	}
+	if got := NewFFmpegDecoder().scaleFilter(1280, 720); got != "scale=1280:720:flags="+DefaultScaler {
+		t.Errorf("scaleFilter without a scaler = %q, want %s", got, DefaultScaler)
+	}
+	if _, err := ParseScaler("bogus"); err == nil {
+		t.Errorf("ParseScaler accepted an unknown scaler")
+	}
+}
+
+func TestReferenceAndDistortedScaleAlike(t *testing.T) {
+	argsFile := filepath.Join(t.TempDir(), "args")
+	t.Setenv("ARGS_FILE", argsFile)
+	f := NewFFmpegDecoder()
+	f.FFmpegPath = fakeBinary(t, "ffmpeg", recordArgs)
+	f.Scaler = "lanczos"
+
+	want := "scale=1280:720:flags=lanczos"
+	if got := argAfter(f.frameRangeArgs("mezzanine.mp4", 1280, 720, 0, 0), "-vf"); got != want {
+		t.Errorf("reference -vf %q, want %q", got, want)
+	}
+	if got := argAfter(f.frameRangeArgs("variant_0.ts", 1280, 720, 0, 0), "-vf"); got != want {
+		t.Errorf("distorted -vf %q, want %q", got, want)
+	}
+	output := filepath.Join(t.TempDir(), "window.yuv")
+	if err := f.DecodeWindowToWidthAndHeight(context.Background(), "mezzanine.mp4", output, 1280, 720, 2, 30); err != nil {
+		t.Fatal(err)
+	}
+	if got := argAfter(recordedArgs(t, argsFile), "-vf"); got != want {
+		t.Errorf("window -vf %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FrameSelection picks the frames scored out of each input, by frame number so the reference and
+// distorted decodes always keep the same frames
+type FrameSelection struct {
+	// Keyframes scores the frames that are keyframes of the mezzanine
+	Keyframes bool
+	// Every scores every Nth frame, starting with the first
+	Every uint64
+	// Frames are the frame numbers scored, counting from 0
+	Frames []uint64
+}
+
+// ParseFrameSelection validates a --frame-select value, keyframes, every:N or a comma separated list
+// of frame numbers
+func ParseFrameSelection(in string) (*FrameSelection, error) {
+	switch {
+	case in == "keyframes":
+		return &FrameSelection{Keyframes: true}, nil
+	case strings.HasPrefix(in, "every:"):
+		every, err := strconv.ParseUint(strings.TrimPrefix(in, "every:"), 10, 64)
+		if err != nil || every == 0 {
+			return nil, fmt.Errorf("Invalid frame selection %q, every:N needs a positive N", in)
+		}
+		return &FrameSelection{Every: every}, nil
+	}
+	var frames []uint64
+	for _, part := range strings.Split(in, ",") {
+		frame, err := strconv.ParseUint(strings.TrimSpace(part), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid frame selection %q, must be keyframes, every:N or a comma separated list of frame numbers", in)
+		}
+		frames = append(frames, frame)
+	}
+	return &FrameSelection{Frames: uniqueFrames(frames)}, nil
+}
+
+// uniqueFrames sorts frame numbers, dropping repeats
+func uniqueFrames(frames []uint64) []uint64 {
+	sorted := append([]uint64(nil), frames...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	var unique []uint64
+	for i, frame := range sorted {
+		if i == 0 || frame != sorted[i-1] {
+			unique = append(unique, frame)
+		}
+	}
+	return unique
+}
+
+// Count is how many of frames [start, end) are selected
+func (s *FrameSelection) Count(start, end uint64) uint64 {
+	if start >= end {
+		return 0
+	}
+	if s.Every > 0 {
+		first := (start + s.Every - 1) / s.Every
+		last := (end - 1) / s.Every
+		return last - first + 1
+	}
+	var count uint64
+	for _, frame := range s.Frames {
+		if frame >= start && frame < end {
+			count++
+		}
+	}
+	return count
+}
+
+// Expr is the ffmpeg select filter expression keeping the selected frames, on the frame numbers n of
+// the input. Runs of consecutive frames are folded into between() terms.
+func (s *FrameSelection) Expr() string {
+	if s.Every > 0 {
+		return fmt.Sprintf("not(mod(n,%d))", s.Every)
+	}
+	var terms []string
+	for i := 0; i < len(s.Frames); {
+		j := i
+		for j+1 < len(s.Frames) && s.Frames[j+1] == s.Frames[j]+1 {
+			j++
+		}
+		if i == j {
+			terms = append(terms, fmt.Sprintf("eq(n,%d)", s.Frames[i]))
+		} else {
+			terms = append(terms, fmt.Sprintf("between(n,%d,%d)", s.Frames[i], s.Frames[j]))
+		}
+		i = j + 1
+	}
+	if len(terms) == 0 {
+		return "0"
+	}
+	return strings.Join(terms, "+")
+}
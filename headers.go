@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// headerFlag collects repeated --header "Key: Value" flags
+type headerFlag struct {
+	header http.Header
+}
+
+func (h *headerFlag) String() string {
+	if h == nil || len(h.header) == 0 {
+		return ""
+	}
+	var entries []string
+	for key, values := range h.header {
+		for _, value := range values {
+			entries = append(entries, key+": "+value)
+		}
+	}
+	return strings.Join(entries, ", ")
+}
+
+func (h *headerFlag) Set(entry string) error {
+	key, value, err := parseHeader(entry)
+	if err != nil {
+		return err
+	}
+	if h.header == nil {
+		h.header = http.Header{}
+	}
+	h.header.Add(key, value)
+	return nil
+}
+
+// parseHeader splits a "Key: Value" header, rejecting names that aren't HTTP tokens and values
+// that would break out of ffmpeg's CRLF separated -headers
+func parseHeader(entry string) (string, string, error) {
+	key, value, ok := strings.Cut(entry, ":")
+	if !ok {
+		return "", "", fmt.Errorf("malformed header %q, must be \"Key: Value\"", entry)
+	}
+	if key == "" || strings.IndexFunc(key, func(r rune) bool { return !isTokenRune(r) }) >= 0 {
+		return "", "", fmt.Errorf("malformed header %q, %q isn't a valid header name", entry, key)
+	}
+	value = strings.TrimSpace(value)
+	if strings.ContainsAny(value, "\r\n") {
+		return "", "", fmt.Errorf("malformed header %q, the value must not contain line breaks", key)
+	}
+	return key, value, nil
+}
+
+// isTokenRune reports whether r may appear in an HTTP header name
+func isTokenRune(r rune) bool {
+	if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+		return true
+	}
+	return strings.ContainsRune("!#$%&'*+-.^_`|~", r)
+}
+
+// cookieFlag collects repeated --cookie flags, each a Set-Cookie style "name=value; path=/; ..."
+type cookieFlag []string
+
+func (c *cookieFlag) String() string {
+	if c == nil {
+		return ""
+	}
+	return strings.Join(*c, ", ")
+}
+
+func (c *cookieFlag) Set(entry string) error {
+	if _, err := cookiePair(entry); err != nil {
+		return err
+	}
+	*c = append(*c, entry)
+	return nil
+}
+
+// cookiePair returns the name=value of a cookie, dropping its attributes
+func cookiePair(entry string) (string, error) {
+	pair, _, _ := strings.Cut(entry, ";")
+	name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+	if !ok || name == "" || strings.IndexFunc(name, func(r rune) bool { return !isTokenRune(r) }) >= 0 {
+		return "", fmt.Errorf("malformed cookie %q, must start with name=value", entry)
+	}
+	if strings.ContainsAny(entry, "\r\n") {
+		return "", fmt.Errorf("malformed cookie %q, it must not contain line breaks", name)
+	}
+	return name + "=" + value, nil
+}
+
+// cookieHeader renders cookies as the Cookie header of a request
+func cookieHeader(cookies []string) string {
+	pairs := make([]string, 0, len(cookies))
+	for _, cookie := range cookies {
+		// already validated by cookieFlag
+		pair, _ := cookiePair(cookie)
+		pairs = append(pairs, pair)
+	}
+	return strings.Join(pairs, "; ")
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// fakeBinary writes a shell script standing in for ffmpeg, ffprobe or vmaf into its own directory
+func fakeBinary(t *testing.T, name, script string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(path, []byte("#!/bin/sh\n"+script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// recordArgs is a fake binary script writing its arguments, one per line, to the file in $ARGS_FILE
+const recordArgs = `printf '%s\n' "$@" > "$ARGS_FILE"` + "\n"
+
+// recordedArgs reads the arguments a fake binary was run with
+func recordedArgs(t *testing.T, path string) []string {
+	t.Helper()
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSuffix(string(raw), "\n"), "\n")
+}
+
+// argAfter is the argument following flag, or "" when flag wasn't passed
+func argAfter(args []string, flag string) string {
+	for i, arg := range args {
+		if arg == flag && i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	return ""
+}
+
+// writeVMAFLog writes a VMAF JSON log with one frame per score
+func writeVMAFLog(t *testing.T, path string, scores ...float64) {
+	t.Helper()
+	var frames []string
+	for i, score := range scores {
+		frames = append(frames, `{"frameNum":`+strconv.Itoa(i)+`,"metrics":{"vmaf":`+strconv.FormatFloat(score, 'f', -1, 64)+`}}`)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(`{"frames":[`+strings.Join(frames, ",")+`]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
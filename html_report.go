@@ -0,0 +1,191 @@
+package main
+
+import (
+	_ "embed"
+	"fmt"
+	"html/template"
+	"os"
+	"strings"
+)
+
+//go:embed report.html.tmpl
+var htmlReportTemplate string
+
+// Layout of the report's VMAF per rendition bar chart, in SVG user units
+const (
+	chartBarWidth   = 48
+	chartBarGap     = 16
+	chartPlotHeight = 240
+	chartMargin     = 32
+)
+
+// htmlReport is what report.html.tmpl renders, with every number already formatted
+type htmlReport struct {
+	Asset     string
+	Label     string
+	Average   string
+	Model     string
+	Reference string
+	Pool      string
+	Partial   bool
+	// Grid is set when the average is over the viewer population rather than per rendition
+	Grid bool
+
+	Variants []htmlVariant
+	Chart    htmlChart
+
+	// Columns are the resolutions of the effective VMAF matrix anything was scored at, Rows its
+	// bandwidth buckets
+	Columns []string
+	Rows    []htmlRow
+}
+
+type htmlVariant struct {
+	Index      int
+	Bandwidth  uint32
+	Resolution string
+	Users      string
+	Score      string
+}
+
+type htmlChart struct {
+	Width, Height int
+	Baseline      int
+	Bars          []htmlBar
+}
+
+type htmlBar struct {
+	X, Y, Width, Height int
+	LabelX, LabelY      int
+	Label, Score        string
+}
+
+type htmlRow struct {
+	Label string
+	Users string
+	Cells []string
+}
+
+// renditionScore is the score a rendition is charted with, that at its own resolution when it was
+// scored there and otherwise its best
+func renditionScore(report *RunReport, variant int) (float64, bool) {
+	if v := report.Variants[variant].VMAF; v != nil {
+		return *v, true
+	}
+	best, found := 0.0, false
+	for _, score := range report.Scores {
+		if score.Variant != variant {
+			continue
+		}
+		if score.Width == report.Variants[variant].Width && score.Height == report.Variants[variant].Height {
+			return score.VMAF, true
+		}
+		if !found || score.VMAF > best {
+			best, found = score.VMAF, true
+		}
+	}
+	return best, found
+}
+
+// newHTMLReport lays out the run report for report.html.tmpl
+func newHTMLReport(report *RunReport, asset string, precision int, percentFormat string) *htmlReport {
+	label := "VMAF"
+	if report.Metric != "" {
+		label = strings.ToUpper(report.Metric)
+	}
+	out := &htmlReport{
+		Asset:     asset,
+		Label:     label,
+		Average:   formatScore(report.AverageVMAF, precision),
+		Model:     report.Model,
+		Reference: report.Reference,
+		Pool:      report.Pool,
+		Partial:   report.Partial,
+		Grid:      report.CompareResolution == "",
+	}
+	out.Chart.Height = chartPlotHeight + 2*chartMargin
+	out.Chart.Baseline = chartMargin + chartPlotHeight
+	for i, variant := range report.Variants {
+		row := htmlVariant{
+			Index:      i,
+			Bandwidth:  variant.Bandwidth,
+			Resolution: fmt.Sprintf("%dx%d", variant.Width, variant.Height),
+			Score:      "-",
+		}
+		if i+1 < len(report.UserPcts) {
+			row.Users = formatShare(report.UserPcts[i+1], percentFormat)
+		}
+		score, ok := renditionScore(report, i)
+		if ok {
+			row.Score = formatScore(score, precision)
+		}
+		out.Variants = append(out.Variants, row)
+
+		// scores are charted against 100, whatever the metric a bar only makes sense scaled to it
+		height := int(score / 100 * chartPlotHeight)
+		if height > chartPlotHeight {
+			height = chartPlotHeight
+		}
+		if height < 0 {
+			height = 0
+		}
+		x := chartMargin + i*(chartBarWidth+chartBarGap)
+		out.Chart.Bars = append(out.Chart.Bars, htmlBar{
+			X:      x,
+			Y:      out.Chart.Baseline - height,
+			Width:  chartBarWidth,
+			Height: height,
+			LabelX: x + chartBarWidth/2,
+			LabelY: out.Chart.Baseline - height - 6,
+			Label:  fmt.Sprintf("%d kbps", variant.Bandwidth/1000),
+			Score:  row.Score,
+		})
+	}
+	out.Chart.Width = 2*chartMargin + len(report.Variants)*(chartBarWidth+chartBarGap)
+
+	// only the columns of the matrix anything was scored at, the 16 pixel grid is mostly empty
+	var columns []int
+	for j := range report.ResolutionWidths {
+		for _, row := range report.EffectiveVMAFs {
+			if j < len(row) && row[j] != 0 {
+				columns = append(columns, j)
+				break
+			}
+		}
+	}
+	for _, j := range columns {
+		out.Columns = append(out.Columns, fmt.Sprintf("%d", report.ResolutionWidths[j]))
+	}
+	for i, row := range report.EffectiveVMAFs {
+		label := "Below the lowest rendition"
+		if i > 0 && i-1 < len(report.Variants) {
+			label = fmt.Sprintf("Variant %d (%d bps)", i-1, report.Variants[i-1].Bandwidth)
+		}
+		bucket := htmlRow{Label: label}
+		if i < len(report.UserPcts) {
+			bucket.Users = formatShare(report.UserPcts[i], percentFormat)
+		}
+		for _, j := range columns {
+			bucket.Cells = append(bucket.Cells, formatScore(row[j], precision))
+		}
+		out.Rows = append(out.Rows, bucket)
+	}
+	return out
+}
+
+// writeHTMLReport renders the run report as a self-contained HTML page
+func writeHTMLReport(path string, report *RunReport, asset string, precision int, percentFormat string) error {
+	tmpl, err := template.New("report").Parse(htmlReportTemplate)
+	if err != nil {
+		return fmt.Errorf("Failed to parse the HTML report template: %v", err)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := tmpl.Execute(f, newHTMLReport(report, asset, precision, percentFormat)); err != nil {
+		f.Close()
+		return fmt.Errorf("Failed to render the HTML report: %v", err)
+	}
+	return f.Close()
+}
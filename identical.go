@@ -0,0 +1,51 @@
+package main
+
+import "context"
+
+// identicalVMAF and identicalPSNR are the scores given to a variant whose frames are identical
+// to the reference's, PSNR uses the 60dB cap vmaf applies to 8-bit content
+const (
+	identicalVMAF = 100.0
+	identicalPSNR = 60.0
+)
+
+var identicalResult = VMAFResult{
+	VMAF:   identicalVMAF,
+	PSNR:   identicalPSNR,
+	SSIM:   1,
+	MSSSIM: 1,
+	Spread: VMAFSpread{P5: identicalVMAF, P95: identicalVMAF, Min: identicalVMAF},
+}
+
+// framesIdentical reports whether both inputs decode to the same frames [start, end) at
+// widthxheight. The first frame of the range is compared on its own first, so differing inputs
+// are usually ruled out after a single frame.
+func framesIdentical(ctx context.Context, decoder Decoder, reference, distorted string, width, height, start, end uint64) (bool, error) {
+	// differ compares the frames of a range, also returning how many were compared
+	differ := func(start, end uint64) (bool, int, error) {
+		referenceHashes, err := decoder.FrameHashesAtWidthAndHeight(ctx, reference, width, height, start, end)
+		if err != nil {
+			return false, 0, err
+		}
+		distortedHashes, err := decoder.FrameHashesAtWidthAndHeight(ctx, distorted, width, height, start, end)
+		if err != nil {
+			return false, 0, err
+		}
+		if len(referenceHashes) != len(distortedHashes) {
+			return true, 0, nil
+		}
+		for i := range referenceHashes {
+			if referenceHashes[i] != distortedHashes[i] {
+				return true, i, nil
+			}
+		}
+		return false, len(referenceHashes), nil
+	}
+
+	// a frame selection may leave the first frame out, in which case only the full range tells
+	if differs, _, err := differ(start, start+1); err != nil || differs {
+		return false, err
+	}
+	differs, compared, err := differ(start, end)
+	return !differs && compared > 0, err
+}
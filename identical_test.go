@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+// hashDecoder hashes canned frames, recording the ranges it was asked for
+type hashDecoder struct {
+	Decoder
+	frames map[string][]string
+	ranges [][2]uint64
+}
+
+func (d *hashDecoder) FrameHashesAtWidthAndHeight(ctx context.Context, filename string, width, height, start, end uint64) ([]string, error) {
+	d.ranges = append(d.ranges, [2]uint64{start, end})
+	frames := d.frames[filename]
+	if end > uint64(len(frames)) {
+		end = uint64(len(frames))
+	}
+	if start >= end {
+		return nil, nil
+	}
+	return frames[start:end], nil
+}
+
+func TestFramesIdenticalHashesOnlyScoredFrames(t *testing.T) {
+	decoder := &hashDecoder{frames: map[string][]string{
+		"reference": {"a", "b", "c", "d"},
+		"distorted": {"x", "b", "c", "y"},
+	}}
+	identical, err := framesIdentical(context.Background(), decoder, "reference", "distorted", 1920, 1080, 1, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !identical {
+		t.Errorf("frames [1, 3) differ, want identical")
+	}
+	want := [][2]uint64{{1, 2}, {1, 2}, {1, 3}, {1, 3}}
+	if !reflect.DeepEqual(decoder.ranges, want) {
+		t.Errorf("hashed ranges %v, want %v", decoder.ranges, want)
+	}
+}
+
+func TestFramesIdenticalStopsAtDifferingFirstFrame(t *testing.T) {
+	decoder := &hashDecoder{frames: map[string][]string{
+		"reference": {"a", "b", "c"},
+		"distorted": {"x", "b", "c"},
+	}}
+	identical, err := framesIdentical(context.Background(), decoder, "reference", "distorted", 1920, 1080, 0, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identical {
+		t.Errorf("first frames differ, want not identical")
+	}
+	if len(decoder.ranges) != 2 {
+		t.Errorf("hashed %d ranges, want just the first frame of both inputs", len(decoder.ranges))
+	}
+}
+
+func TestFrameRangeArgsApplyFrameSelect(t *testing.T) {
+	f := NewFFmpegDecoder()
+	f.FrameSelect = "not(mod(n,10))"
+	args := f.frameRangeArgs("in.mp4", 1920, 1080, 5, 50)
+	if got := argAfter(args, "-vf"); got != "select='between(n,5,49)*(not(mod(n,10)))',setpts=N/FRAME_RATE/TB,"+f.scaleFilter(1920, 1080) {
+		t.Errorf("-vf %q doesn't select frames [5, 50)", got)
+	}
+}
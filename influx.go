@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// InfluxPoint is a single score exported as an InfluxDB line protocol record
+type InfluxPoint struct {
+	Tags  map[string]string
+	Value float64
+}
+
+var (
+	influxMeasurementEscaper = strings.NewReplacer(",", "\\,", " ", "\\ ")
+	influxTagEscaper         = strings.NewReplacer(",", "\\,", " ", "\\ ", "=", "\\=")
+)
+
+// parseInfluxTags parses a comma separated list of key=value tags
+func parseInfluxTags(in string) (map[string]string, error) {
+	tags := map[string]string{}
+	if in == "" {
+		return tags, nil
+	}
+	for _, pair := range strings.Split(in, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return nil, fmt.Errorf("invalid influx tag %q, must be key=value", pair)
+		}
+		tags[kv[0]] = kv[1]
+	}
+	return tags, nil
+}
+
+// writeInfluxLines renders points as line protocol, merging in the tags shared by every point
+func writeInfluxLines(w io.Writer, measurement string, sharedTags map[string]string, points []InfluxPoint, timestamp time.Time) error {
+	for _, point := range points {
+		tags := map[string]string{}
+		for k, v := range sharedTags {
+			tags[k] = v
+		}
+		for k, v := range point.Tags {
+			tags[k] = v
+		}
+
+		// influx prefers tags sorted by key
+		keys := make([]string, 0, len(tags))
+		for k := range tags {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		line := influxMeasurementEscaper.Replace(measurement)
+		for _, k := range keys {
+			line += "," + influxTagEscaper.Replace(k) + "=" + influxTagEscaper.Replace(tags[k])
+		}
+		value := strconv.FormatFloat(point.Value, 'f', -1, 64)
+		if _, err := fmt.Fprintf(w, "%s value=%s %d\n", line, value, timestamp.UnixNano()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeInfluxFile(path, measurement string, sharedTags map[string]string, points []InfluxPoint) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	if err := writeInfluxLines(w, measurement, sharedTags, points, time.Now()); err != nil {
+		return err
+	}
+	return w.Flush()
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+)
+
+// JUnitTestSuites is the root element of a JUnit XML report
+type JUnitTestSuites struct {
+	XMLName  xml.Name          `xml:"testsuites"`
+	Tests    int               `xml:"tests,attr"`
+	Failures int               `xml:"failures,attr"`
+	Time     float64           `xml:"time,attr"`
+	Suites   []*JUnitTestSuite `xml:"testsuite"`
+}
+
+// JUnitTestSuite groups the scored resolutions of one rendition
+type JUnitTestSuite struct {
+	Name      string           `xml:"name,attr"`
+	Tests     int              `xml:"tests,attr"`
+	Failures  int              `xml:"failures,attr"`
+	Time      float64          `xml:"time,attr"`
+	TestCases []*JUnitTestCase `xml:"testcase"`
+}
+
+// JUnitTestCase is a single rendition scored at one resolution
+type JUnitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      float64       `xml:"time,attr"`
+	Failure   *JUnitFailure `xml:"failure,omitempty"`
+}
+
+// JUnitFailure marks a test case whose score missed the quality gate
+type JUnitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// junitReport renders each scored resolution as a test case, with one suite per rendition.
+// Scores below minVMAF fail, a minVMAF of 0 passes everything.
+func junitReport(report *RunReport, minVMAF float64, precision int) *JUnitTestSuites {
+	suites := &JUnitTestSuites{}
+	variantSuites := make([]*JUnitTestSuite, len(report.Variants))
+	for _, score := range report.Scores {
+		variant := report.Variants[score.Variant]
+		suite := variantSuites[score.Variant]
+		if suite == nil {
+			suite = &JUnitTestSuite{Name: fmt.Sprintf("variant %d (%d bps) %s", score.Variant, variant.Bandwidth, variant.URI)}
+			variantSuites[score.Variant] = suite
+			suites.Suites = append(suites.Suites, suite)
+		}
+
+		testCase := &JUnitTestCase{
+			Name:      fmt.Sprintf("%dx%d", score.Width, score.Height),
+			ClassName: fmt.Sprintf("vmaf_analyzer.variant_%d", score.Variant),
+			Time:      score.DurationSeconds,
+		}
+		if minVMAF > 0 && score.VMAF < minVMAF {
+			message := fmt.Sprintf("VMAF %s is below --min-vmaf %s", formatScore(score.VMAF, precision), formatScore(minVMAF, precision))
+			testCase.Failure = &JUnitFailure{Message: message, Type: "QualityGate", Text: message}
+			suite.Failures++
+			suites.Failures++
+		}
+		suite.TestCases = append(suite.TestCases, testCase)
+		suite.Tests++
+		suite.Time += score.DurationSeconds
+		suites.Tests++
+		suites.Time += score.DurationSeconds
+	}
+	return suites
+}
+
+func writeJUnitReport(path string, suites *JUnitTestSuites) error {
+	out, err := xml.MarshalIndent(suites, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append([]byte(xml.Header), out...), 0644)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"sort"
+)
+
+// ladderVerdictTolerance is how far the mean delta may stray from zero before a ladder is
+// called better or worse than the reference
+const ladderVerdictTolerance = 0.5
+
+// RenditionComparison is one rendition's VMAF against the reference ladder's at the same bandwidth
+type RenditionComparison struct {
+	Variant       int     `json:"variant"`
+	Bandwidth     uint32  `json:"bandwidth"`
+	VMAF          float64 `json:"vmaf"`
+	ReferenceVMAF float64 `json:"reference_vmaf"`
+	Delta         float64 `json:"delta"`
+	// OutOfRange is set when the bandwidth is outside the reference ladder's and the nearest
+	// reference rendition was used instead of interpolating
+	OutOfRange bool `json:"out_of_range,omitempty"`
+}
+
+// LadderComparison compares a ladder against a reference ladder from an earlier run
+type LadderComparison struct {
+	Reference  string                `json:"reference"`
+	Renditions []RenditionComparison `json:"renditions"`
+	MeanDelta  float64               `json:"mean_delta"`
+	Verdict    string                `json:"verdict"`
+}
+
+type ladderPoint struct {
+	Variant   int
+	Bandwidth uint32
+	VMAF      float64
+}
+
+func loadRunReport(path string) (*RunReport, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var report RunReport
+	if err := json.Unmarshal(raw, &report); err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// ladderCurve picks each rendition's score at its native resolution and sorts them by bandwidth
+func ladderCurve(report *RunReport) []ladderPoint {
+	var curve []ladderPoint
+	for i, variant := range report.Variants {
+		if variant.VMAF != nil {
+			curve = append(curve, ladderPoint{i, variant.Bandwidth, *variant.VMAF})
+			continue
+		}
+		for _, score := range report.Scores {
+			if score.Variant == i && score.Width == variant.Width {
+				curve = append(curve, ladderPoint{i, variant.Bandwidth, score.VMAF})
+				break
+			}
+		}
+	}
+	sort.Slice(curve, func(i, j int) bool { return curve[i].Bandwidth < curve[j].Bandwidth })
+	return curve
+}
+
+// interpolateVMAF reads the curve at bandwidth, interpolating linearly on log bandwidth like
+// rate-quality curves usually are. Outside the curve the nearest end is used and ok is false.
+func interpolateVMAF(curve []ladderPoint, bandwidth uint32) (vmaf float64, ok bool) {
+	if bandwidth <= curve[0].Bandwidth {
+		return curve[0].VMAF, bandwidth == curve[0].Bandwidth
+	}
+	last := curve[len(curve)-1]
+	if bandwidth >= last.Bandwidth {
+		return last.VMAF, bandwidth == last.Bandwidth
+	}
+	for i := 1; i < len(curve); i++ {
+		lo, hi := curve[i-1], curve[i]
+		if bandwidth > hi.Bandwidth {
+			continue
+		}
+		if hi.Bandwidth == lo.Bandwidth {
+			return hi.VMAF, true
+		}
+		t := (math.Log(float64(bandwidth)) - math.Log(float64(lo.Bandwidth))) / (math.Log(float64(hi.Bandwidth)) - math.Log(float64(lo.Bandwidth)))
+		return lo.VMAF + t*(hi.VMAF-lo.VMAF), true
+	}
+	return last.VMAF, false
+}
+
+// compareLadders scores each rendition of report against the reference ladder's rate-VMAF curve
+func compareLadders(report, reference *RunReport, referencePath string, precision int) (*LadderComparison, error) {
+	if report.Reference != reference.Reference {
+		return nil, fmt.Errorf("reference ladder %q was scored against the %s, but this run against the %s", referencePath, reference.Reference, report.Reference)
+	}
+	referenceCurve := ladderCurve(reference)
+	if len(referenceCurve) == 0 {
+		return nil, fmt.Errorf("reference ladder %q has no renditions scored at their native resolution", referencePath)
+	}
+
+	comparison := &LadderComparison{Reference: referencePath, Renditions: []RenditionComparison{}}
+	totalDelta := 0.0
+	for _, point := range ladderCurve(report) {
+		referenceVMAF, ok := interpolateVMAF(referenceCurve, point.Bandwidth)
+		comparison.Renditions = append(comparison.Renditions, RenditionComparison{
+			Variant:       point.Variant,
+			Bandwidth:     point.Bandwidth,
+			VMAF:          point.VMAF,
+			ReferenceVMAF: roundScore(referenceVMAF, precision),
+			Delta:         roundScore(point.VMAF-referenceVMAF, precision),
+			OutOfRange:    !ok,
+		})
+		totalDelta += point.VMAF - referenceVMAF
+	}
+	if len(comparison.Renditions) == 0 {
+		return nil, fmt.Errorf("no renditions were scored at their native resolution to compare against the reference ladder")
+	}
+
+	meanDelta := totalDelta / float64(len(comparison.Renditions))
+	comparison.MeanDelta = roundScore(meanDelta, precision)
+	switch {
+	case meanDelta > ladderVerdictTolerance:
+		comparison.Verdict = "better"
+	case meanDelta < -ladderVerdictTolerance:
+		comparison.Verdict = "worse"
+	default:
+		comparison.Verdict = "equivalent"
+	}
+	return comparison, nil
+}
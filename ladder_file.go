@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/grafov/m3u8"
+)
+
+// LadderEntry is one rendition of a --ladder file, an encode on disk and the bandwidth it's
+// published at
+type LadderEntry struct {
+	Path      string `json:"path"`
+	Bandwidth uint32 `json:"bandwidth"`
+}
+
+// loadLadderFile reads a JSON list of ladder entries into variants whose URIs are the rendition
+// files, relative paths being resolved against the ladder file's directory
+func loadLadderFile(path string) ([]*m3u8.Variant, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var entries []LadderEntry
+	if err := json.Unmarshal(raw, &entries); err != nil {
+		return nil, fmt.Errorf("Failed to parse ladder: %v", err)
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("Ladder has no renditions")
+	}
+
+	var variants []*m3u8.Variant
+	for i, entry := range entries {
+		if entry.Path == "" {
+			return nil, fmt.Errorf("Ladder rendition %d has no path", i)
+		}
+		if entry.Bandwidth == 0 {
+			return nil, fmt.Errorf("Ladder rendition %q has no bandwidth", entry.Path)
+		}
+		file := entry.Path
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(filepath.Dir(path), file)
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read ladder rendition %q: %v", entry.Path, err)
+		}
+		if info.IsDir() {
+			return nil, fmt.Errorf("Ladder rendition %q is a directory", entry.Path)
+		}
+		variants = append(variants, &m3u8.Variant{
+			URI:           file,
+			VariantParams: m3u8.VariantParams{Bandwidth: entry.Bandwidth},
+		})
+	}
+	return variants, nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import "fmt"
+
+const (
+	// loopDriftThreshold is how far apart repetition scores may be before they're reported as drifting
+	loopDriftThreshold = 1.0
+)
+
+// detectLoopPeriod finds the shortest period, in frames, at which the content repeats itself
+// at least twice, returning 0 when the content doesn't loop
+func detectLoopPeriod(hashes []string) int {
+	for period := 1; period <= len(hashes)/2; period++ {
+		looped := true
+		for i := 0; i+period < len(hashes); i++ {
+			if hashes[i] != hashes[i+period] {
+				looped = false
+				break
+			}
+		}
+		if looped {
+			return period
+		}
+	}
+	return 0
+}
+
+// repetitionScores pools the per-frame VMAF of each complete repetition of a looping clip like the
+// pair's score
+func repetitionScores(frames []*VMAFFrame, period, totalFrames int, method PoolMethod, policy ZeroPolicy) ([]float64, error) {
+	repetitions := totalFrames / period
+	if repetitions == 0 {
+		return nil, nil
+	}
+
+	repetitionFrames := make([][]*VMAFFrame, repetitions)
+	for _, frame := range frames {
+		repetition := frame.FrameNum / period
+		if repetition < repetitions {
+			repetitionFrames[repetition] = append(repetitionFrames[repetition], frame)
+		}
+	}
+
+	pooled := make([]float64, 0, repetitions)
+	for i, repetition := range repetitionFrames {
+		if len(repetition) == 0 {
+			continue
+		}
+		score, err := poolFrames(repetition, method, policy)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to pool repetition %d: %v", i, err)
+		}
+		pooled = append(pooled, score)
+	}
+	return pooled, nil
+}
+
+// scoreDrift returns the spread between the best and worst repetition scores
+func scoreDrift(scores []float64) float64 {
+	if len(scores) == 0 {
+		return 0
+	}
+	min, max := scores[0], scores[0]
+	for _, score := range scores {
+		if score < min {
+			min = score
+		}
+		if score > max {
+			max = score
+		}
+	}
+	return max - min
+}
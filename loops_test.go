@@ -0,0 +1,21 @@
+package main
+
+import "testing"
+
+func TestRepetitionScoresPoolLikeThePair(t *testing.T) {
+	var frames []*VMAFFrame
+	for i, score := range []float64{60, 90, 0, 90} {
+		frames = append(frames, &VMAFFrame{FrameNum: i, Metrics: &VMAFMetrics{VMAF: score, HasVMAF: true}})
+	}
+
+	scores, err := repetitionScores(frames, 2, 4, PoolMin, ZeroPolicyDrop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scores) != 2 || scores[0] != 60 || scores[1] != 90 {
+		t.Errorf("repetition scores %v, want the zero dropped and the rest min pooled", scores)
+	}
+	if _, err := repetitionScores(frames, 2, 4, PoolHarmonicMean, ZeroPolicyFail); err == nil {
+		t.Errorf("repetition with a zero score pooled with the fail zero policy")
+	}
+}
@@ -6,11 +6,16 @@ import (
 	"flag"
 	"fmt"
 	"io/ioutil"
-	"net/http"
+	"log/slog"
+	"math"
 	"os"
-	"sort"
-	"sync"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/grafov/m3u8"
 )
@@ -18,20 +23,121 @@ import (
 const (
 	resolutionsLen      = 120
 	bandwidthsLen       = 100
-	mezzanineDecodePath = "/tmp/mezzanine.yuv"
-	distortedDecodePath = "/tmp/distorted.yuv"
+	bandwidthBucketKbps = 100
+	defaultWorkDir      = "/tmp"
+	mezzanineDecodeName = "mezzanine.yuv"
+	distortedDecodeName = "distorted.yuv"
 	logsDir             = "logs"
 	minVmafResolution   = 192
-	lowVMAFThreshold    = 0.0
+	maxDurationDelta    = 0.5
+	distributionEpsilon = 0.01
 )
 
+// defaultLowVMAFThreshold is well below what even the lowest rung scores upscaled, but catches
+// swapped or mismatched inputs
+const defaultLowVMAFThreshold = 10.0
+
 var (
-	subsample = flag.Int("subsample", 30, "What vmaf subsampling factor to use")
-	threads   = flag.Int("threads", 10, "How many threads used to run vmaf")
-	model     = flag.String("model", "vmaf/model/vmaf_v0.6.1.pkl", "vmaf model to use")
-	dataFile  = flag.String("datafile", "data.json", "Location of the data file to use for processing")
+	subsample             = flag.Int("subsample", 1, "What vmaf subsampling factor to use, scoring every Nth frame (1 scores every frame)")
+	threads               = flag.Int("threads", 10, "How many threads used to run vmaf")
+	pipelineDepth         = flag.Int("pipeline-depth", 1, "How many variant and resolution pairs are decoded and scored at once, each running vmaf with --threads")
+	maxThreads            = flag.Int("max-threads", 0, "Machine wide budget of vmaf threads shared by the pairs scored at once, defaulting --pipeline-depth to fit it (0 for no budget)")
+	model                 = flag.String("model", "vmaf/model/vmaf_v0.6.1.pkl", "vmaf model to use")
+	ffmpegPath            = flag.String("ffmpeg", "", "Path to the ffmpeg binary (defaults to ffmpeg on PATH)")
+	ffprobePath           = flag.String("ffprobe", "", "Path to the ffprobe binary (defaults to ffprobe on PATH)")
+	phoneModel            = flag.Bool("phone-model", false, "Score with the model's phone transform, for viewing on small screens")
+	minResolution         = flag.Uint64("min-resolution", 0, fmt.Sprintf("Smallest width or height to score, defaults to the model's (%d for the 1080p models)", minVmafResolution))
+	modelSHA256Flag       = flag.String("model-sha256", "", "Fail unless the --model file has this SHA-256 checksum")
+	estimator             = flag.String("estimator", EstimatorVMAF, "VMAF implementation to score with: vmaf (vmafossexec on the CPU), libvmaf (libvmaf's vmaf tool on the CPU) or vmaf-cuda (ffmpeg's libvmaf_cuda filter on a CUDA GPU)")
+	showVersion           = flag.Bool("version", false, "Print the analyzer's build and the ffmpeg, ffprobe and VMAF versions it runs, then exit")
+	dataFile              = flag.String("datafile", "data.json", "Location of the data file to use for processing, a path, an http(s) URL or - for stdin")
+	ladderFile            = flag.String("ladder", "", "JSON list of local rendition files and their bandwidths to score instead of a manifest's variants")
+	keepIntermediates     = flag.Bool("keep-intermediates", false, "Leave the dumped variants and decode FIFOs behind once the run ends")
+	dryRun                = flag.Bool("dry-run", false, "Probe, dump and validate the inputs and data file, then print what would be scored without running VMAF")
+	workDir               = flag.String("work-dir", defaultWorkDir, "Directory the decode FIFOs and VMAF logs are created in")
+	mezzCacheDir          = flag.String("mezz-cache-dir", "", "Cache the mezzanine decoded at each resolution under this directory, reusing it across variants")
+	frameTolerance        = flag.Uint64("frame-tolerance", 0, "How many frames a variant may differ from the mezzanine by, both are truncated to the shorter length")
+	videoStreamIndex      = flag.Int("video-stream-index", 0, "Which of the mezzanine's video streams to use as the reference, counting from 0")
+	bandwidthBuckets      = flag.Int("bandwidth-buckets", bandwidthsLen, "Number of bandwidth buckets in the data file, the last of which is open ended")
+	bandwidthBucketWidth  = flag.Int("bandwidth-bucket-kbps", bandwidthBucketKbps, "Width of each data file bandwidth bucket in kbps")
+	netrc                 = flag.String("netrc", "", "netrc file holding origin credentials, looked up for each request's host (defaults to $NETRC or ~/.netrc)")
+	onlyVariant           = flag.Int("only-variant", -1, "Only score the variant at this index in bandwidth order, for debugging one rendition")
+	onlyResolution        = flag.String("only-resolution", "", "Only score at this WxH resolution of the grid or --compare-resolution")
+	mode                  = flag.String("mode", ModeGrid, "grid averages over the viewer population, per-variant scores each rendition once at its native resolution without a data file")
+	compareResolution     = flag.String("compare-resolution", "", "Score each variant once at a fixed WxH, 'native' or 'mezzanine' resolution instead of the full resolution grid")
+	hmeanZeroPolicy       = flag.String("hmean-zero-policy", "none", "How zero-VMAF frames are treated before the harmonic mean: none, drop, clamp or fail")
+	detectLoops           = flag.Bool("detect-loops", false, "Detect repeating content in the mezzanine and check VMAF is stable across repetitions")
+	influxOutput          = flag.String("influx-output", "", "Write scores as InfluxDB line protocol to this file")
+	influxMeasurement     = flag.String("influx-measurement", "vmaf", "Measurement name used for InfluxDB line protocol records")
+	influxTags            = flag.String("influx-tags", "", "Extra comma separated key=value tags added to every InfluxDB record")
+	referenceFromVariant  = flag.String("reference-from-variant", "", "Use a ladder rendition as the reference instead of a mezzanine ('top' for the highest bandwidth), producing relative scores")
+	frameSelect           = flag.String("frame-select", "", "Only score these frames of both inputs: keyframes (of the mezzanine), every:N or a comma separated list of frame numbers")
+	segmentDuration       = flag.Duration("segment-duration", 0, "Score long content in windows of about this long, such as 5m, each decode seeking to its window (0 scores it all at once)")
+	segmentReport         = flag.String("segment-report", "", "Write VMAF pooled per HLS media segment to this CSV file")
+	dumpDir               = flag.String("dump-dir", ".", "Directory dumped variants are written to")
+	dumpOnly              = flag.Bool("dump-only", false, "Only download the manifest's variants into --dump-dir, without running VMAF")
+	nice                  = flag.Int("nice", 0, "Niceness applied to the ffmpeg, ffprobe and vmafossexec child processes")
+	cpuAffinity           = flag.String("cpu-affinity", "", "CPU list such as 0-3,6 the child processes are pinned to (linux only)")
+	variantRegex          = flag.String("variant-regex", "", "Only analyze variants whose URI, RESOLUTION, CODECS or NAME matches this regex")
+	resultPrecision       = flag.Int("result-precision", 3, "Number of decimal places scores are reported with")
+	dashSegments          = flag.Int("dash-segments", 0, "Only analyze the first n SegmentTemplate segments of each DASH representation (0 for all)")
+	outputJSON            = flag.String("output-json", "", "Write a machine readable JSON report of the run to this file")
+	htmlReportPath        = flag.String("html-report", "", "Write a self-contained HTML report with a chart of the scores to this file")
+	skipIdentical         = flag.Bool("skip-identical", false, "Hash the scored frames of variants at their native resolution and score those identical to the reference 100 without running VMAF")
+	strict                = flag.Bool("strict", false, "Fail instead of warning when the mezzanine and variants look swapped")
+	lowVMAFThreshold      = flag.Float64("low-vmaf-threshold", defaultLowVMAFThreshold, "Abort the run when a pair scores below this VMAF, which usually means the inputs are misconfigured or swapped (0 disables the check)")
+	minVMAF               = flag.Float64("min-vmaf", 0, "Fail the run when any scored rendition falls below this VMAF (0 disables the gate)")
+	abortOnFirstLow       = flag.Bool("abort-on-first-low", false, "Abort the sweep as soon as a score falls below --min-vmaf instead of completing it")
+	pareto                = flag.String("pareto-output", "", "Write the ladder's bandwidth vs VMAF Pareto frontier to this JSON file")
+	efficiencyOutlier     = flag.String("efficiency-outlier", defaultEfficiencyOutlier, "Flag renditions whose VMAF per Mbps is below ratio:X of their neighbors', or zscore:X standard deviations below the ladder's trend")
+	reportJUnit           = flag.String("report-junit", "", "Write each scored rendition as a JUnit XML test case, failing those below --min-vmaf")
+	allowDurationMismatch = flag.Bool("allow-duration-mismatch", false, "Only warn, instead of failing, when a variant's duration differs from the mezzanine's")
+	allowFPSMismatch      = flag.Bool("allow-frame-rate-mismatch", false, "Only warn, instead of failing, when a variant's frame rate differs from the mezzanine's")
+	compareManifest       = flag.String("compare", "", "Also score this other ladder's manifest the same way and report its BD-rate against the first")
+	referenceLadder       = flag.String("compare-to-reference-ladder-vmaf", "", "Compare each rendition's VMAF against the ladder in this earlier --output-json report at the same bandwidth")
+	dumpConcurrency       = flag.Int("dump-concurrency", 1, "How many variants are downloaded at once")
+	httpRetries           = flag.Int("http-retries", 3, "How many times network errors and 5xx responses fetching manifests and segments are retried")
+	httpTimeout           = flag.Duration("http-timeout", 60*time.Second, "Timeout of each manifest and segment request (0 for none)")
+	statusURL             = flag.String("status-url", "", "PUT JSON progress updates for the run to this URL")
+	timeout               = flag.Duration("timeout", 0, "Abort the whole run after this long, killing any running ffmpeg and VMAF (0 for no limit)")
+	statusInterval        = flag.Duration("status-interval", 30*time.Second, "Minimum time between --status-url progress updates")
+	trimFramesStart       = flag.Uint64("trim-frames-start", 0, "Exclude this many encoder warm-up frames at the start of both inputs from scoring")
+	trimFramesEnd         = flag.Uint64("trim-frames-end", 0, "Exclude this many flush frames at the end of both inputs from scoring")
+	strictData            = flag.Bool("strict-data", false, "Fail when a data file distribution doesn't sum to 1 within --data-epsilon")
+	normalizeData         = flag.Bool("normalize-data", false, "Rescale data file distributions that don't sum to 1 within --data-epsilon")
+	dataEpsilon           = flag.Float64("data-epsilon", distributionEpsilon, "How far a data file distribution's sum may be from 1")
+	perFrameCSV           = flag.Bool("per-frame-csv", false, "Write the per-frame VMAF, PSNR, SSIM and MS-SSIM of every run to a CSV next to its VMAF log")
+	weightBy              = flag.String("weight-by", WeightByPopulation, "Weight the average VMAF by share of viewers (population) or share of watch time (viewtime)")
+	percentFormat         = flag.String("percent-format", PercentFormatPercent, "Report shares of users as a percentage like 25.0% (percent) or a fraction like 0.250 (fraction)")
+	scaler                = flag.String("scaler", DefaultScaler, "Scaling algorithm both inputs are resized with before scoring, such as bicubic or lanczos")
+	pixFmt                = flag.String("pix-fmt", DefaultPixelFormat.Name, "Raw pixel format inputs are decoded to and vmaf reads, such as yuv420p10le for 10-bit renditions")
+	withPSNR              = flag.Bool("with-psnr", true, "Compute PSNR alongside VMAF")
+	withSSIM              = flag.Bool("with-ssim", true, "Compute SSIM alongside VMAF")
+	withMSSSIM            = flag.Bool("with-ms-ssim", true, "Compute MS-SSIM alongside VMAF")
+	vmafOnly              = flag.Bool("vmaf-only", false, "Compute only VMAF, skipping PSNR, SSIM and MS-SSIM for a faster run")
+	metricFlag            = flag.String("metric", string(MetricVMAF), "Score to average and gate on: vmaf, or psnr or ssim for a quick screen that skips the model (libvmaf estimator only)")
+	poolMethod            = flag.String("pool", string(PoolHarmonicMean), "How per-frame VMAF scores are pooled: mean, min, max or harmonic_mean")
+	variantAttributesFlag = flag.Bool("manifest-variant-attributes", false, "Report each variant's declared CODECS, RESOLUTION, FRAME-RATE, VIDEO-RANGE and HDCP-LEVEL, flagging those that don't match the video")
+	logLevel              = flag.String("log-level", "info", "Minimum level logged to stderr: debug, info, warn or error")
+	verbose               = flag.Bool("verbose", false, "Log every step of scoring each pair and the output of the tools run, shorthand for --log-level debug")
+	logFormat             = flag.String("log-format", LogFormatText, "Format logs are written in: text or json")
+	pipeSize              = flag.Int("pipe-size", 0, "Buffer size in bytes of the decode FIFOs, which can speed up 4K analysis (linux only, 0 for the system default)")
 )
 
+// Repeatable flags. requestHeaders and requestCookies are sent with the manifest and segment
+// requests, referenceYUV maps resolutions onto pre-decoded mezzanines.
+var (
+	requestHeaders headerFlag
+	requestCookies cookieFlag
+	referenceYUV   = referenceYUVFlag{}
+)
+
+func init() {
+	flag.Var(&requestHeaders, "header", "Extra \"Key: Value\" HTTP header sent with manifest and segment requests (repeatable)")
+	flag.Var(&requestCookies, "cookie", "Cookie such as \"name=value; path=/; domain=example.com\" sent with manifest and segment requests (repeatable)")
+	flag.Var(referenceYUV, "reference-yuv", "WxH=path of the mezzanine already decoded to raw --pix-fmt frames at that resolution, fed to vmaf instead of decoding it (repeatable)")
+}
+
 // ByBandwidth implements sort.Interface for []*m3u8.Variant based on the Bandwidth field.
 type ByBandwidth []*m3u8.Variant
 
@@ -41,10 +147,187 @@ func (v ByBandwidth) Less(i, j int) bool { return v[i].Bandwidth < v[j].Bandwidt
 
 // DataFile represents the current environment data
 // Resolutions are represented by *widths* in 16-pixel buckets
-// Bandwidths are represented by *kbps* in 100Kbps buckets
+// Bandwidths are represented by *kbps* in 100Kbps buckets by default, the last bucket
+// holds every user at or above its lower bound
 type DataFile struct {
 	ResolutionPcts []float64 `json:"resolution_pcts"`
 	BandwidthPcts  []float64 `json:"bandwidth_pcts"`
+
+	// ViewTimePcts optionally holds the same distributions as shares of watch time
+	ViewTimePcts *ViewTimePcts `json:"view_time_pcts,omitempty"`
+
+	// Resolutions replaces the 16 pixel resolution buckets with an explicit list of widths, each
+	// with its share of viewers. The view time resolution shares then follow the same list.
+	Resolutions []ResolutionWeight `json:"resolutions,omitempty"`
+}
+
+// ResolutionWeight is the share of viewers watching at a width
+type ResolutionWeight struct {
+	Width uint64  `json:"width"`
+	Pct   float64 `json:"pct"`
+}
+
+// resolutionGrid returns the widths of the resolution buckets, filling ResolutionPcts in from
+// Resolutions when the data file lists them explicitly
+func (d *DataFile) resolutionGrid() ([]uint64, error) {
+	if len(d.Resolutions) == 0 {
+		if err := validateDistribution("resolution", d.ResolutionPcts, resolutionsLen); err != nil {
+			return nil, err
+		}
+		widths := make([]uint64, len(d.ResolutionPcts))
+		for j := range widths {
+			widths[j] = uint64((j + 1) * 16)
+		}
+		return widths, nil
+	}
+
+	if len(d.ResolutionPcts) > 0 {
+		return nil, fmt.Errorf("Invalid input data; resolutions and resolution_pcts can't both be given")
+	}
+	widths := make([]uint64, len(d.Resolutions))
+	d.ResolutionPcts = make([]float64, len(d.Resolutions))
+	for j, weight := range d.Resolutions {
+		if weight.Width == 0 || weight.Width%2 != 0 {
+			return nil, fmt.Errorf("Invalid input data; resolution width %d must be even and above 0", weight.Width)
+		}
+		if j > 0 && weight.Width <= widths[j-1] {
+			return nil, fmt.Errorf("Invalid input data; resolution widths must be in increasing order, but %d follows %d", weight.Width, widths[j-1])
+		}
+		widths[j] = weight.Width
+		d.ResolutionPcts[j] = weight.Pct
+	}
+	return widths, nil
+}
+
+// ViewTimePcts are the shares of watch time in each resolution and bandwidth bucket
+type ViewTimePcts struct {
+	ResolutionPcts []float64 `json:"resolution_pcts"`
+	BandwidthPcts  []float64 `json:"bandwidth_pcts"`
+}
+
+// Viewer weightings for the average, by share of viewers or by share of watch time
+const (
+	WeightByPopulation = "population"
+	WeightByViewTime   = "viewtime"
+)
+
+// Ways shares of users are reported, as percentages or as the data file's fractions
+const (
+	PercentFormatPercent  = "percent"
+	PercentFormatFraction = "fraction"
+)
+
+// weights returns the resolution and bandwidth distributions to average over
+func (d *DataFile) weights(weightBy string) ([]float64, []float64, error) {
+	switch weightBy {
+	case "", WeightByPopulation:
+		return d.ResolutionPcts, d.BandwidthPcts, nil
+	case WeightByViewTime:
+		if d.ViewTimePcts == nil {
+			return nil, nil, fmt.Errorf("Weighting by %s needs view_time_pcts in the data file", WeightByViewTime)
+		}
+		if len(d.ViewTimePcts.ResolutionPcts) != len(d.ResolutionPcts) {
+			return nil, nil, fmt.Errorf("Invalid input data; expected %d view time resolution entries but got %d", len(d.ResolutionPcts), len(d.ViewTimePcts.ResolutionPcts))
+		}
+		return d.ViewTimePcts.ResolutionPcts, d.ViewTimePcts.BandwidthPcts, nil
+	}
+	return nil, nil, fmt.Errorf("Unknown weighting %q, must be %s or %s", weightBy, WeightByPopulation, WeightByViewTime)
+}
+
+// RunReport is the machine readable summary of an analysis run
+type RunReport struct {
+	Model       string `json:"model"`
+	ModelSHA256 string `json:"model_sha256,omitempty"`
+	Reference   string `json:"reference"`
+	Pool        string `json:"pool"`
+	Scaler      string `json:"scaler,omitempty"`
+	WeightBy    string `json:"weight_by,omitempty"`
+	// Transform is set when scores were transformed, they're only comparable with runs using the same one
+	Transform string `json:"transform,omitempty"`
+	// LogsDir is where the run's VMAF logs were written
+	LogsDir string `json:"logs_dir,omitempty"`
+	// Partial is set when only some variants or resolutions were scored, so the average doesn't
+	// cover the whole ladder
+	Partial bool `json:"partial,omitempty"`
+	// Metric is what the VMAF fields hold when a cheaper metric than VMAF was scored
+	Metric            string          `json:"metric,omitempty"`
+	MezzanineWidth    uint64          `json:"mezzanine_width"`
+	MezzanineHeight   uint64          `json:"mezzanine_height"`
+	CompareResolution string          `json:"compare_resolution,omitempty"`
+	TrimFramesStart   uint64          `json:"trim_frames_start,omitempty"`
+	TrimFramesEnd     uint64          `json:"trim_frames_end,omitempty"`
+	Variants          []VariantReport `json:"variants"`
+
+	// Scores holds every variant and resolution pair that was scored, in the order they ran
+	Scores   []ResolutionScore `json:"scores,omitempty"`
+	Segments []SegmentScore    `json:"segments,omitempty"`
+	Pareto   *ParetoReport     `json:"pareto,omitempty"`
+
+	ReferenceLadder *LadderComparison `json:"reference_ladder,omitempty"`
+	BDRate          *BDRateComparison `json:"bd_rate,omitempty"`
+	Efficiency      *EfficiencyReport `json:"efficiency,omitempty"`
+
+	// UserPcts and the rows of EffectiveVMAFs are indexed by bandwidth bucket, where bucket 0
+	// holds users who can't sustain any variant and bucket i+1 holds users of variant i. The
+	// columns of EffectiveVMAFs are the widths of ResolutionWidths.
+	UserPcts         []float64   `json:"user_pcts,omitempty"`
+	EffectiveVMAFs   [][]float64 `json:"effective_vmafs,omitempty"`
+	ResolutionWidths []uint64    `json:"resolution_widths,omitempty"`
+	AverageVMAF      float64     `json:"average_vmaf"`
+
+	// StageSeconds is the time spent probing, dumping, decoding and computing VMAF
+	StageSeconds map[string]float64 `json:"stage_seconds,omitempty"`
+	// Versions are the builds of the analyzer, ffmpeg and VMAF that produced the report
+	Versions *ToolVersions `json:"versions,omitempty"`
+}
+
+// VariantReport describes one rendition of the ladder
+type VariantReport struct {
+	URI        string   `json:"uri"`
+	Path       string   `json:"path"`
+	Bandwidth  uint32   `json:"bandwidth"`
+	Width      uint64   `json:"width"`
+	Height     uint64   `json:"height"`
+	FrameCount uint64   `json:"frame_count"`
+	Duration   float64  `json:"duration"`
+	VMAF       *float64 `json:"vmaf,omitempty"`
+	// Codec, Profile and Level describe the encode, for spotting say an accidental baseline profile
+	Codec   string `json:"codec,omitempty"`
+	Profile string `json:"profile,omitempty"`
+	Level   string `json:"level,omitempty"`
+
+	Attributes *VariantAttributes `json:"attributes,omitempty"`
+}
+
+// ResolutionScore is the VMAF of one variant decoded to one resolution
+type ResolutionScore struct {
+	Variant int     `json:"variant"`
+	Width   uint64  `json:"width"`
+	Height  uint64  `json:"height"`
+	VMAF    float64 `json:"vmaf"`
+	// PSNR, SSIM and MS-SSIM are frame means, omitted when the estimator doesn't compute them
+	PSNR   float64 `json:"psnr,omitempty"`
+	SSIM   float64 `json:"ssim,omitempty"`
+	MSSSIM float64 `json:"ms_ssim,omitempty"`
+	// Identical is set when the frames matched the reference and VMAF wasn't run
+	Identical bool `json:"identical,omitempty"`
+
+	// VMAFStdDev, VMAFP5, VMAFP95 and VMAFMin describe the spread of the per-frame VMAF scores
+	VMAFStdDev float64 `json:"vmaf_stddev"`
+	VMAFP5     float64 `json:"vmaf_p5"`
+	VMAFP95    float64 `json:"vmaf_p95"`
+	VMAFMin    float64 `json:"vmaf_min"`
+
+	// DurationSeconds is how long decoding and scoring the pair took
+	DurationSeconds float64 `json:"duration_seconds"`
+}
+
+func writeRunReport(path string, report *RunReport) error {
+	out, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, out, 0644)
 }
 
 func sumFloat64Array(in []float64) float64 {
@@ -55,271 +338,929 @@ func sumFloat64Array(in []float64) float64 {
 	return result
 }
 
-func widthToHeight(width, mezzanineWidth, mezzanineHeight uint64) uint64 {
-	scalingFactor := float64(mezzanineHeight) / float64(mezzanineWidth)
+// validateDistribution checks a data file distribution has the expected number of buckets, each
+// a fraction in [0,1]
+func validateDistribution(name string, pcts []float64, buckets int) error {
+	if len(pcts) != buckets {
+		return fmt.Errorf("Invalid input data; expected %d %s entries but got %d", buckets, name, len(pcts))
+	}
+	for i, pct := range pcts {
+		if pct < 0 || pct > 1 {
+			return fmt.Errorf("Invalid input data; %s entry %d is %f, which isn't between 0 and 1", name, i, pct)
+		}
+	}
+	return nil
+}
+
+// DataSumPolicy says what to do with a distribution that doesn't sum to 1 within Epsilon, which
+// would otherwise scale the average VMAF by its sum
+type DataSumPolicy struct {
+	Epsilon float64
+	// Strict fails the run, Normalize rescales the distribution, otherwise it's only a warning
+	Strict    bool
+	Normalize bool
+}
+
+// check applies the policy to a distribution, returning the distribution to use
+func (p DataSumPolicy) check(name string, pcts []float64) ([]float64, error) {
+	sum := sumFloat64Array(pcts)
+	if math.Abs(sum-1) <= p.Epsilon {
+		return pcts, nil
+	}
+	switch {
+	case p.Strict:
+		return nil, fmt.Errorf("Invalid input data; %s entries sum to %f rather than 1", name, sum)
+	case p.Normalize && sum > 0:
+		normalized := make([]float64, len(pcts))
+		for i, pct := range pcts {
+			normalized[i] = pct / sum
+		}
+		slog.Info("Normalized data file entries", "distribution", name, "sum", sum, "normalized_sum", sumFloat64Array(normalized))
+		return normalized, nil
+	}
+	slog.Warn("Data file entries don't sum to 1, scaling the average VMAF by the same factor", "distribution", name, "sum", sum)
+	return pcts, nil
+}
+
+// widthToHeight is the even height keeping the mezzanine's display aspect ratio at the given
+// width, so anamorphic mezzanines are compared at the shape they're shown at
+func widthToHeight(width uint64, mezzanine *FFProbeStream) uint64 {
+	scalingFactor := float64(mezzanine.Height) / (float64(mezzanine.Width) * mezzanine.PixelAspectRatio())
 	height := uint64(scalingFactor*float64(width)) >> 1 << 1
 	return height
 }
 
+// formatScore renders a score for text output with the given number of decimal places
+func formatScore(score float64, precision int) string {
+	return strconv.FormatFloat(score, 'f', precision, 64)
+}
+
+// formatShare formats a share of users, such as 0.25, as 25.0% or as 0.250 for PercentFormatFraction
+func formatShare(share float64, format string) string {
+	if format == PercentFormatFraction {
+		return strconv.FormatFloat(share, 'f', 3, 64)
+	}
+	return strconv.FormatFloat(share*100, 'f', 1, 64) + "%"
+}
+
+// roundScore rounds a score to the given number of decimal places for machine readable output
+func roundScore(score float64, precision int) float64 {
+	scale := math.Pow(10, float64(precision))
+	return math.Round(score*scale) / scale
+}
+
+// variantMatches reports whether the regex matches a variant's URI or any of its descriptive attributes
+func variantMatches(re *regexp.Regexp, variant *m3u8.Variant) bool {
+	for _, attr := range []string{variant.URI, variant.Resolution, variant.Codecs, variant.Name} {
+		if attr != "" && re.MatchString(attr) {
+			return true
+		}
+	}
+	return false
+}
+
+// bandwidthUserPcts sums the users of each bandwidth bucket onto the highest variant they can
+// sustain, expects variants sorted by bandwidth. Index 0 holds users who can't sustain any
+// variant and index i+1 the users of variant i. Variants above the lower bound of the last,
+// open ended, bucket can't be attributed any users.
+func bandwidthUserPcts(bandwidthPcts []float64, bucketWidth uint64, variants []*m3u8.Variant) []float64 {
+	userPcts := make([]float64, len(variants)+1)
+	curVariant := 0
+	for i, userPct := range bandwidthPcts {
+		for curVariant < len(variants) && uint64(i)*bucketWidth >= uint64(variants[curVariant].Bandwidth) {
+			curVariant++
+		}
+		userPcts[curVariant] += userPct
+	}
+	return userPcts
+}
+
+// nativeResolutionBucket returns the resolution bucket matching a variant's own width, or -1
+// when the width doesn't land on a bucket
+func nativeResolutionBucket(widths []uint64, width uint64) int {
+	for j, bucketWidth := range widths {
+		if bucketWidth == width {
+			return j
+		}
+	}
+	return -1
+}
+
+// variantDumpPath is where the variant at the given bandwidth-sorted index is dumped to, in a
+// container with the given extension
+func variantDumpPath(dir string, variant int, ext string) string {
+	return filepath.Join(dir, fmt.Sprintf("variant_%d%s", variant, ext))
+}
+
+// parseResolution parses a WxH string such as 1280x720
+func parseResolution(in string) (uint64, uint64, error) {
+	parts := strings.Split(strings.ToLower(in), "x")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("resolution %q must be of the form WxH", in)
+	}
+	width, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid width in resolution %q: %v", in, err)
+	}
+	height, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid height in resolution %q: %v", in, err)
+	}
+	return width, height, nil
+}
+
+// Analysis modes accepted by --mode
+const (
+	ModeGrid       = "grid"
+	ModePerVariant = "per-variant"
+)
+
+// compareDimensions resolves a --compare-resolution value for one variant and validates the result
+func compareDimensions(resolution string, mezzanine, variant *FFProbeStream, minResolution uint64) (uint64, uint64, error) {
+	var width, height uint64
+	switch resolution {
+	case "native":
+		width, height = variant.Width, variant.Height
+	case "mezzanine":
+		width, height = mezzanine.Width, mezzanine.Height
+	default:
+		var err error
+		if width, height, err = parseResolution(resolution); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	if width%2 != 0 || height%2 != 0 {
+		return 0, 0, fmt.Errorf("resolution %dx%d must have even dimensions", width, height)
+	}
+	if width < minResolution || height < minResolution {
+		return 0, 0, fmt.Errorf("resolution %dx%d is too small for VMAF", width, height)
+	}
+	if width > mezzanine.Width || height > mezzanine.Height {
+		return 0, 0, fmt.Errorf("resolution %dx%d exceeds the mezzanine's %dx%d", width, height, mezzanine.Width, mezzanine.Height)
+	}
+	return width, height, nil
+}
+
+// Exit codes, so scripts can tell why a run failed
+const (
+	exitOK      = 0
+	exitFailure = 1
+	exitUsage   = 2
+	exitProbe   = 3
+	exitVMAF    = 4
+	exitTimeout = 5
+	// exitInterrupted follows the shell's 128 + SIGINT
+	exitInterrupted = 130
+)
+
+// exitCode maps an Analyze error onto the process exit code
+func exitCode(err error) int {
+	if err == nil {
+		return exitOK
+	}
+	if stageErr, ok := err.(*StageError); ok {
+		switch stageErr.Stage {
+		case StageProbe:
+			return exitProbe
+		case StageVMAF:
+			return exitVMAF
+		}
+	}
+	return exitFailure
+}
+
+// cancelOnSignal cancels the context on SIGINT or SIGTERM, which kills the ffmpeg and VMAF children
+// and has Analyze remove its FIFOs on the way out. A second signal exits straight away.
+func cancelOnSignal(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-signals:
+			slog.Warn("Stopping the run, signal again to exit immediately", "signal", sig.String())
+			signal.Stop(signals)
+			cancel()
+		case <-ctx.Done():
+			signal.Stop(signals)
+		}
+	}()
+	return ctx, cancel
+}
+
+// prepareVMAF creates the decode FIFOs and the logs directory used by VMAF
+func prepareVMAF(logsPath string, fifos ...string) error {
+	slog.Debug("Preparing for VMAF")
+	if err := os.MkdirAll(logsPath, 0700); err != nil {
+		return fmt.Errorf("Failed to create logs directory %q: %v", logsPath, err)
+	}
+	for _, path := range fifos {
+		if err := ensureFifo(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ensureFifo makes sure path is a FIFO, reusing one left over from an earlier run. A regular
+// file left by a crashed run is replaced, as decoding into it would block VMAF forever.
+func ensureFifo(path string) error {
+	err := syscall.Mkfifo(path, 0600)
+	if err != syscall.EEXIST {
+		if err != nil {
+			return fmt.Errorf("Failed to create FIFO %q: %v", path, err)
+		}
+		return nil
+	}
+
+	info, err := os.Lstat(path)
+	if err != nil {
+		return fmt.Errorf("Failed to check existing FIFO %q: %v", path, err)
+	}
+	if info.Mode()&os.ModeNamedPipe != 0 {
+		return nil
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%q exists and is not a FIFO or regular file", path)
+	}
+	slog.Warn("Replacing stale file with a FIFO", "path", path)
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("Failed to remove stale file %q: %v", path, err)
+	}
+	if err := syscall.Mkfifo(path, 0600); err != nil {
+		return fmt.Errorf("Failed to create FIFO %q: %v", path, err)
+	}
+	return nil
+}
+
+// extraMetricsFromFlags are the metrics computed alongside VMAF per --with-psnr, --with-ssim,
+// --with-ms-ssim and --vmaf-only
+func extraMetricsFromFlags() (ExtraMetrics, error) {
+	if !*vmafOnly {
+		return ExtraMetrics{PSNR: *withPSNR, SSIM: *withSSIM, MSSSIM: *withMSSSIM}, nil
+	}
+	if (flagSet("with-psnr") && *withPSNR) || (flagSet("with-ssim") && *withSSIM) || (flagSet("with-ms-ssim") && *withMSSSIM) {
+		return ExtraMetrics{}, fmt.Errorf("--vmaf-only skips every other metric and can't be combined with --with-psnr, --with-ssim or --with-ms-ssim")
+	}
+	return ExtraMetrics{}, nil
+}
+
+// flagSet reports whether the named flag was given on the command line
+func flagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
 func printUsage() {
 	fmt.Fprintf(os.Stderr, "Usage: vmaf_analyzer [--subsample n] [--threads n] [--model vmaf_v0.6.1.pkl] [--datafile data.json] mezzanine.mp4 https://example.com/hls_stream.m3u8\n")
+	fmt.Fprintf(os.Stderr, "       vmaf_analyzer --reference-from-variant top [options] https://example.com/hls_stream.m3u8\n")
+	fmt.Fprintf(os.Stderr, "       vmaf_analyzer --dump-only [--dump-dir dir] https://example.com/hls_stream.m3u8\n")
+	fmt.Fprintf(os.Stderr, "       vmaf_analyzer --ladder ladder.json [options] mezzanine.mp4\n")
 	flag.PrintDefaults()
 }
 
+// renderOutputs writes the report to every output file that was asked for
+func renderOutputs(report *RunReport, asset string) bool {
+	if *influxOutput != "" {
+		extraTags, _ := parseInfluxTags(*influxTags)
+
+		// influx records tagged by asset, rendition and resolution
+		sharedTags := map[string]string{"asset": asset}
+		if report.Reference == "top-variant" {
+			sharedTags["reference"] = report.Reference
+		}
+		for k, v := range extraTags {
+			sharedTags[k] = v
+		}
+		var points []InfluxPoint
+		for _, score := range report.Scores {
+			points = append(points, InfluxPoint{
+				Tags:  map[string]string{"rendition": strconv.Itoa(score.Variant), "resolution": fmt.Sprintf("%dx%d", score.Width, score.Height)},
+				Value: score.VMAF,
+			})
+		}
+		if report.CompareResolution == "" {
+			points = append(points, InfluxPoint{Tags: map[string]string{"rendition": "average"}, Value: report.AverageVMAF})
+		}
+		if err := writeInfluxFile(*influxOutput, *influxMeasurement, sharedTags, points); err != nil {
+			slog.Error("Failed to write InfluxDB output", "error", err)
+			return false
+		}
+		slog.Info("Wrote InfluxDB records", "records", len(points), "path", *influxOutput)
+	}
+
+	if *segmentReport != "" {
+		if err := writeSegmentScores(*segmentReport, report.Segments); err != nil {
+			slog.Error("Failed to write segment report", "error", err)
+			return false
+		}
+		slog.Info("Wrote segment scores", "segments", len(report.Segments), "path", *segmentReport)
+	}
+
+	if *outputJSON != "" {
+		if err := writeRunReport(*outputJSON, report); err != nil {
+			slog.Error("Failed to write JSON report", "error", err)
+			return false
+		}
+		slog.Info("Wrote JSON report", "path", *outputJSON)
+	}
+
+	if *htmlReportPath != "" {
+		if err := writeHTMLReport(*htmlReportPath, report, asset, *resultPrecision, *percentFormat); err != nil {
+			slog.Error("Failed to write HTML report", "error", err)
+			return false
+		}
+		slog.Info("Wrote HTML report", "path", *htmlReportPath)
+	}
+
+	if *reportJUnit != "" {
+		suites := junitReport(report, *minVMAF, *resultPrecision)
+		if err := writeJUnitReport(*reportJUnit, suites); err != nil {
+			slog.Error("Failed to write JUnit report", "error", err)
+			return false
+		}
+		slog.Info("Wrote JUnit test cases", "tests", suites.Tests, "path", *reportJUnit)
+	}
+
+	if *pareto != "" && report.Pareto != nil {
+		for _, point := range report.Pareto.Dominated {
+			slog.Info("Variant is dominated", "variant", point.Variant, "bandwidth", point.Bandwidth, "vmaf", roundScore(point.VMAF, *resultPrecision), "dominated_by", *point.DominatedBy)
+		}
+		if err := writeParetoReport(*pareto, report.Pareto); err != nil {
+			slog.Error("Failed to write Pareto frontier", "error", err)
+			return false
+		}
+		total := len(report.Pareto.Frontier) + len(report.Pareto.Dominated)
+		slog.Info("Wrote Pareto frontier", "frontier", len(report.Pareto.Frontier), "variants", total, "path", *pareto)
+	}
+	return true
+}
+
 func main() {
 	flag.Parse()
+	os.Exit(run())
+}
+
+// run does the work of main, returning the process exit code
+func run() int {
+	if *verbose {
+		*logLevel = "debug"
+	}
+	logger, err := newLogger(os.Stderr, *logLevel, *logFormat)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		printUsage()
+		return exitUsage
+	}
+	slog.SetDefault(logger)
+
+	if *showVersion {
+		ffmpegBinary, ffprobeBinary := *ffmpegPath, *ffprobePath
+		if ffmpegBinary == "" {
+			ffmpegBinary = "ffmpeg"
+		}
+		if ffprobeBinary == "" {
+			ffprobeBinary = "ffprobe"
+		}
+		versions := detectToolVersions(context.Background(), ffmpegBinary, ffprobeBinary, *estimator)
+		fmt.Printf("vmaf_analyzer %s\n", versions.Analyzer)
+		for _, tool := range []struct{ name, version string }{
+			{"ffmpeg", versions.FFmpeg},
+			{"ffprobe", versions.FFprobe},
+			{"vmafossexec", versions.VMAFOSSExec},
+			{"vmaf", versions.LibVMAF},
+		} {
+			if tool.version != "" {
+				fmt.Printf("%s: %s\n", tool.name, tool.version)
+			}
+		}
+		return exitOK
+	}
 
-	// must include input mezzanine and master playlist
-	if len(flag.Args()) != 2 {
+	// the top rung stands in for the mezzanine when scoring relative to the ladder itself
+	if *referenceFromVariant != "" && *referenceFromVariant != "top" {
+		fmt.Printf("Unknown --reference-from-variant %q, only 'top' is supported\n", *referenceFromVariant)
+		printUsage()
+		return exitUsage
+	}
+	relative := *referenceFromVariant == "top"
+	if relative && len(referenceYUV) > 0 {
+		fmt.Printf("--reference-yuv holds mezzanine decodes and can't be combined with --reference-from-variant\n")
 		printUsage()
-		return
+		return exitUsage
+	}
+	if *compareManifest != "" && (relative || *dumpOnly) {
+		fmt.Printf("--compare scores both ladders against the mezzanine and can't be combined with --reference-from-variant or --dump-only\n")
+		printUsage()
+		return exitUsage
+	}
+	scoreLabel := strings.ToUpper(*metricFlag)
+	if relative {
+		scoreLabel = "relative " + scoreLabel + " (vs top variant)"
 	}
 
-	// must include path to local mezz input
-	mezzanineFile := flag.Args()[0]
-	if len(mezzanineFile) == 0 {
+	// must include input mezzanine and master playlist, unless the reference comes from the ladder
+	// or nothing is being scored
+	needsMezzanine := !relative && !*dumpOnly
+	var mezzanineFile, manifestURL string
+	if *ladderFile != "" {
+		// the ladder's renditions stand in for the manifest
+		if *dumpOnly {
+			fmt.Printf("--ladder renditions are already on disk and can't be combined with --dump-only\n")
+			printUsage()
+			return exitUsage
+		}
+		switch {
+		case relative && len(flag.Args()) == 0:
+		case !relative && len(flag.Args()) == 1 && len(flag.Args()[0]) > 0:
+			mezzanineFile = flag.Args()[0]
+		default:
+			printUsage()
+			return exitUsage
+		}
+	} else if !needsMezzanine && len(flag.Args()) == 1 {
+		manifestURL = flag.Args()[0]
+	} else if !relative && len(flag.Args()) == 2 {
+		mezzanineFile = flag.Args()[0]
+		manifestURL = flag.Args()[1]
+
+		// must include path to local mezz input
+		if len(mezzanineFile) == 0 {
+			printUsage()
+			return exitUsage
+		}
+	} else {
 		printUsage()
-		return
+		return exitUsage
 	}
 
 	// must include manifest URL
-	manifestURL := flag.Args()[1]
-	if len(manifestURL) == 0 {
+	if len(manifestURL) == 0 && *ladderFile == "" {
+		printUsage()
+		return exitUsage
+	}
+
+	zeroPolicy, err := ParseZeroPolicy(*hmeanZeroPolicy)
+	if err != nil {
+		fmt.Printf("%v\n", err)
 		printUsage()
-		return
+		return exitUsage
 	}
 
-	// ffmpeg decoder
-	ctx := context.Background()
-	ffmpeg := NewFFmpegDecoder()
+	pool, err := ParsePoolMethod(*poolMethod)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		printUsage()
+		return exitUsage
+	}
 
-	// Probe the input file
-	fmt.Printf("Probing mezzanine file %q\n", mezzanineFile)
-	mezzanineInfo, err := ffmpeg.ProbeFile(ctx, mezzanineFile)
+	pixelFormat, err := ParsePixelFormat(*pixFmt)
 	if err != nil {
-		fmt.Printf("Failed to probe file: %v\n", err)
-		return
+		fmt.Printf("%v\n", err)
+		printUsage()
+		return exitUsage
 	}
-	if len(mezzanineInfo.Streams) != 1 {
-		fmt.Printf("Input file must have exactly 1 video stream, but had %d streams\n", len(mezzanineInfo.Streams))
-		return
+
+	scalerName, err := ParseScaler(*scaler)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		printUsage()
+		return exitUsage
 	}
-	videoStream := mezzanineInfo.Streams[0]
-	if videoStream.Width == 0 || videoStream.Height == 0 {
-		fmt.Printf("Input file must have a valid width and height, but has %dx%d", videoStream.Width, videoStream.Height)
-		return
+	var frameSelection *FrameSelection
+	if *frameSelect != "" {
+		if frameSelection, err = ParseFrameSelection(*frameSelect); err != nil {
+			fmt.Printf("%v\n", err)
+			printUsage()
+			return exitUsage
+		}
 	}
-	fmt.Printf("Mezzanine widthxheight: %dx%d\n", videoStream.Width, videoStream.Height)
 
-	// Load the master manfest
-	fmt.Printf("Retrieving master manifest from URI %q\n", manifestURL)
-	resp, err := http.Get(manifestURL)
+	efficiencyRule, err := ParseEfficiencyRule(*efficiencyOutlier)
 	if err != nil {
-		fmt.Printf("Failed to fetch master manfiest (%s): %v\n", manifestURL, err)
-		return
+		fmt.Printf("%v\n", err)
+		printUsage()
+		return exitUsage
 	}
-	defer resp.Body.Close()
 
-	// parse manifest URL for master playlist
-	manifest, manifestType, err := m3u8.DecodeFrom(resp.Body, false)
+	extras, err := extraMetricsFromFlags()
 	if err != nil {
-		fmt.Printf("Failed to decode master manifest: %v", err)
-		return
+		fmt.Printf("%v\n", err)
+		printUsage()
+		return exitUsage
 	}
-	var masterPlaylist *m3u8.MasterPlaylist
-	switch manifestType {
-	case m3u8.MASTER:
-		masterPlaylist = manifest.(*m3u8.MasterPlaylist)
+
+	metric, err := ParseMetric(*metricFlag)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		printUsage()
+		return exitUsage
+	}
+	if metric == MetricVMAF {
+		metric = ""
+	}
+
+	// catch estimator choices that can't work before anything is fetched or decoded
+	switch *estimator {
+	case EstimatorVMAF, EstimatorLibVMAF, EstimatorVMAFCUDA:
 	default:
-		fmt.Printf("Invalid manifest format, must be a master manifest")
-		return
+		fmt.Printf("Unknown --estimator %q, must be %s, %s or %s\n", *estimator, EstimatorVMAF, EstimatorLibVMAF, EstimatorVMAFCUDA)
+		printUsage()
+		return exitUsage
+	}
+	if metric != "" && *estimator != EstimatorLibVMAF {
+		fmt.Printf("--metric %s skips the model, which only the %s estimator can do, add --estimator %s\n", metric, EstimatorLibVMAF, EstimatorLibVMAF)
+		printUsage()
+		return exitUsage
+	}
+	if *estimator == EstimatorVMAFCUDA && !cudaSupports(pixelFormat.Name) {
+		fmt.Printf("--estimator %s only scores %s frames, not --pix-fmt %s\n", EstimatorVMAFCUDA, strings.Join(cudaPixelFormats, " or "), pixelFormat.Name)
+		printUsage()
+		return exitUsage
 	}
 
-	// get variants
-	sortedVariants := masterPlaylist.Variants
-	sort.Sort(ByBandwidth(masterPlaylist.Variants))
-	fmt.Printf("Input has %d variants\n", len(sortedVariants))
+	if *subsample < 0 {
+		fmt.Printf("--subsample must not be negative\n")
+		printUsage()
+		return exitUsage
+	}
 
-	// parse variants and validate
-	variantInfo := make([]*FFProbeOutput, len(sortedVariants))
-	for i, variant := range sortedVariants {
-		fmt.Printf("Dumping variant %d\n", i)
-		if variantInfo[i], err = ffmpeg.DumpStream(ctx, variant.URI, fmt.Sprintf("variant_%d.ts", i)); err != nil {
-			fmt.Printf("Failed to dump stream: %v\n", err)
-			return
-		}
+	if *weightBy != WeightByPopulation && *weightBy != WeightByViewTime {
+		fmt.Printf("Unknown --weight-by %q, must be %s or %s\n", *weightBy, WeightByPopulation, WeightByViewTime)
+		printUsage()
+		return exitUsage
+	}
 
-		if len(variantInfo[i].Streams) != 1 {
-			fmt.Printf("Invalid variant stream has no video track\n")
-			return
-		}
+	if *dataFile == dataFileStdin && *compareManifest != "" {
+		fmt.Printf("--datafile - can only be read once and can't be combined with --compare\n")
+		printUsage()
+		return exitUsage
+	}
 
-		if len(variantInfo[i].Frames) != len(mezzanineInfo.Frames) {
-			fmt.Printf("Variant frame count doesn't match mezzanine frame count: %d != %d\n", len(variantInfo[i].Frames), len(mezzanineInfo.Frames))
-			return
-		}
+	if *percentFormat != PercentFormatPercent && *percentFormat != PercentFormatFraction {
+		fmt.Printf("Unknown --percent-format %q, must be %s or %s\n", *percentFormat, PercentFormatPercent, PercentFormatFraction)
+		printUsage()
+		return exitUsage
+	}
 
-		fmt.Printf("Variant info looks good: %d\n", i)
+	if *strictData && *normalizeData {
+		fmt.Printf("--strict-data and --normalize-data can't be combined\n")
+		printUsage()
+		return exitUsage
+	}
+	if *dataEpsilon < 0 {
+		fmt.Printf("--data-epsilon must not be negative\n")
+		printUsage()
+		return exitUsage
 	}
 
-	// read from user data file
-	fileReader, err := os.Open(*dataFile)
-	if err != nil {
-		fmt.Printf("Failed to load data file: %v", err)
-		return
+	if _, err := parseInfluxTags(*influxTags); err != nil {
+		fmt.Printf("%v\n", err)
+		printUsage()
+		return exitUsage
+	}
+
+	if *resultPrecision < 0 {
+		fmt.Printf("--result-precision must not be negative\n")
+		printUsage()
+		return exitUsage
+	}
+
+	if *abortOnFirstLow && *minVMAF <= 0 {
+		fmt.Printf("--abort-on-first-low needs a --min-vmaf gate\n")
+		printUsage()
+		return exitUsage
+	}
+
+	var variantFilter *regexp.Regexp
+	if *variantRegex != "" {
+		if variantFilter, err = regexp.Compile(*variantRegex); err != nil {
+			fmt.Printf("Invalid --variant-regex: %v\n", err)
+			printUsage()
+			return exitUsage
+		}
 	}
-	defer fileReader.Close()
 
-	rawFile, err := ioutil.ReadAll(fileReader)
+	cpus, err := parseCPUList(*cpuAffinity)
 	if err != nil {
-		fmt.Printf("Failed to read data file: %v", err)
-		return
+		fmt.Printf("%v\n", err)
+		printUsage()
+		return exitUsage
 	}
 
-	// parse data and validate
-	var data DataFile
-	if err := json.Unmarshal(rawFile, &data); err != nil {
-		fmt.Printf("Failed to unmarshal data: %v", err)
-		return
+	if *httpRetries < 0 || *httpTimeout < 0 {
+		fmt.Printf("--http-retries and --http-timeout must not be negative\n")
+		printUsage()
+		return exitUsage
 	}
-	if len(data.BandwidthPcts) != bandwidthsLen {
-		fmt.Printf("Invalid input data; expected %d bandwidth entries but got %d\n", bandwidthsLen, len(data.BandwidthPcts))
-		return
+
+	if *dumpConcurrency < 1 {
+		fmt.Printf("--dump-concurrency must be at least 1\n")
+		printUsage()
+		return exitUsage
 	}
-	fmt.Printf("Bandwidths len: %d sum: %f\n", len(data.BandwidthPcts), sumFloat64Array(data.BandwidthPcts))
-	fmt.Printf("Resolutions len: %d sum: %f\n", len(data.ResolutionPcts), sumFloat64Array(data.ResolutionPcts))
 
-	// calculate user bandwidth percentile within variant
-	userPcts := make([]float64, len(sortedVariants)+1)
-	curVariant := 0
-	for i, userPct := range data.BandwidthPcts {
-		if curVariant == len(sortedVariants) {
-			userPcts[curVariant] += userPct
-			continue
-		}
+	if *videoStreamIndex < 0 {
+		fmt.Printf("--video-stream-index must not be negative\n")
+		printUsage()
+		return exitUsage
+	}
 
-		if uint32(i*100*1000) >= sortedVariants[curVariant].Bandwidth {
-			curVariant++
+	if *bandwidthBuckets < 1 || *bandwidthBucketWidth < 1 {
+		fmt.Printf("--bandwidth-buckets and --bandwidth-bucket-kbps must be at least 1\n")
+		printUsage()
+		return exitUsage
+	}
+
+	if *pipelineDepth < 1 {
+		fmt.Printf("--pipeline-depth must be at least 1\n")
+		printUsage()
+		return exitUsage
+	}
+
+	if *maxThreads < 0 {
+		fmt.Printf("--max-threads must not be negative\n")
+		printUsage()
+		return exitUsage
+	}
+	if *segmentDuration < 0 {
+		fmt.Printf("--segment-duration must not be negative\n")
+		printUsage()
+		return exitUsage
+	}
+	if *maxThreads > 0 {
+		if *threads > *maxThreads {
+			fmt.Printf("--threads %d exceeds --max-threads %d\n", *threads, *maxThreads)
+			printUsage()
+			return exitUsage
+		}
+		// run as many pairs as the budget fits, unless the depth is chosen explicitly
+		if !flagSet("pipeline-depth") && *threads > 0 {
+			*pipelineDepth = *maxThreads / *threads
 		}
-		userPcts[curVariant] += userPct
 	}
-	for i, totalPct := range userPcts {
-		if i == 0 {
-			fmt.Printf("%0.3f of users have insufficient bandwidth for *any* rendition to play smoothly\n", totalPct)
-		} else {
-			fmt.Printf("%0.3f of users have sufficient bandwidth for rendition %d\n", totalPct, i)
+
+	// per-variant is shorthand for comparing at each variant's native resolution
+	switch *mode {
+	case ModeGrid:
+	case ModePerVariant:
+		if *compareResolution != "" && *compareResolution != "native" {
+			fmt.Printf("--mode %s scores at each variant's native resolution and can't be combined with --compare-resolution %s\n", ModePerVariant, *compareResolution)
+			printUsage()
+			return exitUsage
 		}
+		*compareResolution = "native"
+	default:
+		fmt.Printf("Unknown --mode %q, must be %s or %s\n", *mode, ModeGrid, ModePerVariant)
+		printUsage()
+		return exitUsage
 	}
 
-	// build directories for VMAF
-	fmt.Printf("Preparing for VMAF\n")
-	syscall.Mkfifo(mezzanineDecodePath, 0600)
-	syscall.Mkfifo(distortedDecodePath, 0600)
-	os.MkdirAll(logsDir, 0700)
+	if *lowVMAFThreshold < 0 {
+		fmt.Printf("--low-vmaf-threshold must not be negative\n")
+		printUsage()
+		return exitUsage
+	}
 
-	// calculate VMAF for users on bandwidth buckets
-	vmaf := NewVMAFEstimator(mezzanineDecodePath, distortedDecodePath, *model, logsDir, uint64(*threads))
-	effectiveVmafs := make([][]float64, len(userPcts))
-	for i := range userPcts {
-		effectiveVmafs[i] = make([]float64, len(data.ResolutionPcts))
-		if i == 0 {
-			continue
+	if *timeout < 0 {
+		fmt.Printf("--timeout must not be negative\n")
+		printUsage()
+		return exitUsage
+	}
+
+	if *pipeSize < 0 {
+		fmt.Printf("--pipe-size must not be negative\n")
+		printUsage()
+		return exitUsage
+	}
+	if *pipeSize > 0 {
+		if maxSize, err := maxPipeSize(); err != nil {
+			fmt.Printf("Unable to check --pipe-size against the system maximum: %v\n", err)
+		} else if *pipeSize > maxSize {
+			fmt.Printf("--pipe-size %d exceeds the system maximum of %d bytes\n", *pipeSize, maxSize)
+			printUsage()
+			return exitUsage
 		}
+	}
 
-		// calculate vmaf score resolutions at current bitrate bucket
-		for j, resUserPct := range data.ResolutionPcts {
-			cancelCtx, cancelFunc := context.WithCancel(ctx)
-			curWidth := uint64((j + 1) * 16)
-			curHeight := widthToHeight(curWidth, videoStream.Width, videoStream.Height)
+	cfg := AnalyzeConfig{
+		MezzanineFile:         mezzanineFile,
+		ManifestURL:           manifestURL,
+		Ladder:                *ladderFile,
+		DataFile:              *dataFile,
+		VideoStreamIndex:      *videoStreamIndex,
+		BandwidthBuckets:      *bandwidthBuckets,
+		BandwidthBucketKbps:   *bandwidthBucketWidth,
+		Model:                 *model,
+		Estimator:             *estimator,
+		ModelSHA256:           *modelSHA256Flag,
+		FFmpegPath:            *ffmpegPath,
+		FFprobePath:           *ffprobePath,
+		HTTPRetries:           *httpRetries,
+		HTTPTimeout:           *httpTimeout,
+		MinResolution:         *minResolution,
+		PhoneModel:            *phoneModel,
+		Threads:               uint64(*threads),
+		Subsample:             uint64(*subsample),
+		ZeroPolicy:            zeroPolicy,
+		PoolMethod:            pool,
+		Metric:                metric,
+		ExtraMetrics:          &extras,
+		PixelFormat:           pixelFormat,
+		Scaler:                scalerName,
+		FrameSelect:           frameSelection,
+		Limits:                &ProcessLimits{Nice: *nice, CPUAffinity: cpus},
+		PipeSize:              *pipeSize,
+		Netrc:                 *netrc,
+		Headers:               requestHeaders.header,
+		Cookies:               requestCookies,
+		CompareResolution:     *compareResolution,
+		RelativeToTopVariant:  relative,
+		VariantFilter:         variantFilter,
+		DASHSegments:          *dashSegments,
+		DumpDir:               *dumpDir,
+		DumpOnly:              *dumpOnly,
+		DumpConcurrency:       *dumpConcurrency,
+		DetectLoops:           *detectLoops,
+		SegmentScores:         *segmentReport != "",
+		SegmentDuration:       *segmentDuration,
+		Pareto:                *pareto != "",
+		ReferenceLadder:       *referenceLadder,
+		ResultPrecision:       *resultPrecision,
+		MinVMAF:               *minVMAF,
+		LowVMAFThreshold:      *lowVMAFThreshold,
+		Strict:                *strict,
+		OnlyResolution:        *onlyResolution,
+		AbortOnFirstLow:       *abortOnFirstLow,
+		AllowDurationMismatch: *allowDurationMismatch,
+		AllowFPSMismatch:      *allowFPSMismatch,
+		VariantAttributes:     *variantAttributesFlag,
+		SkipIdentical:         *skipIdentical,
+		FrameTolerance:        *frameTolerance,
+		MezzanineCacheDir:     *mezzCacheDir,
+		ReferenceYUV:          referenceYUV,
+		WorkDir:               *workDir,
+		DryRun:                *dryRun,
+		PipelineDepth:         *pipelineDepth,
+		MaxThreads:            *maxThreads,
+		KeepIntermediates:     *keepIntermediates,
+		WeightBy:              *weightBy,
+		PercentFormat:         *percentFormat,
+		PerFrameCSV:           *perFrameCSV,
+		DataSums:              DataSumPolicy{Epsilon: *dataEpsilon, Strict: *strictData, Normalize: *normalizeData},
+		TrimFramesStart:       *trimFramesStart,
+		TrimFramesEnd:         *trimFramesEnd,
+	}
+	if *onlyVariant >= 0 {
+		cfg.OnlyVariant = onlyVariant
+	}
+	if *statusURL != "" {
+		cfg.OnProgress = NewStatusReporter(*statusURL, *statusInterval).Report
+	}
+	ctx, interrupt := cancelOnSignal(context.Background())
+	defer interrupt()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+	// stopped tells a run that timed out or was interrupted apart from one that failed
+	stopped := func(err error) (int, bool) {
+		if err != nil && ctx.Err() == context.DeadlineExceeded {
+			slog.Error("Run timed out", "timeout", *timeout, "error", err)
+			return exitTimeout, true
+		}
+		if err != nil && ctx.Err() == context.Canceled {
+			slog.Error("Run interrupted", "error", err)
+			return exitInterrupted, true
+		}
+		return exitOK, false
+	}
+	report, err := Analyze(ctx, cfg)
+	if code, ok := stopped(err); ok {
+		return code
+	}
+	if report == nil {
+		slog.Error("Analysis failed", "error", err)
+		return exitCode(err)
+	}
 
-			if curWidth < minVmafResolution || curHeight < minVmafResolution {
-				fmt.Printf("Skipping resolution %dx%d - its too small for VMAF\n", curWidth, curHeight)
-				continue
-			}
-			if resUserPct == 0.0 {
-				fmt.Printf("Skipping resolution %dx%d - zero percentage of users watch at this resolution\n", curWidth, curHeight)
-				continue
-			}
+	if *dryRun {
+		slog.Info("Dry run complete, no VMAF was computed")
+		return exitOK
+	}
+
+	// just hand the dumped variants over for use in other tools
+	if *dumpOnly {
+		for i, variant := range report.Variants {
+			fmt.Printf("Variant %d (%d bps): %s\n", i, variant.Bandwidth, variant.Path)
+		}
+		return exitOK
+	}
 
-			fmt.Printf("Calculating VMAF score at %dx%d\n", curWidth, curHeight)
-
-			// decode reference
-			var wg sync.WaitGroup
-			errc := make(chan error, 1)
-			wg.Add(1)
-			go func() {
-				fmt.Printf("Decoding this input: %s\n", mezzanineFile)
-				if err := ffmpeg.DecodeToWidthAndHeight(cancelCtx, mezzanineFile, mezzanineDecodePath, curWidth, curHeight); err != nil {
-					fmt.Printf("Error encountered decoding mezzanine:\n%v\n", err)
-					errc <- err
-				}
-				wg.Done()
-			}()
-
-			// decode distorted
-			wg.Add(1)
-			go func() {
-				distoredFile := fmt.Sprintf("variant_%d.ts", i-1)
-
-				fmt.Printf("Decoding this input: %s\n", distoredFile)
-				if err := ffmpeg.DecodeToWidthAndHeight(cancelCtx, distoredFile, distortedDecodePath, curWidth, curHeight); err != nil {
-					fmt.Printf("Error encountered decoding variant:\n%v\n", err)
-					errc <- err
-				}
-				wg.Done()
-			}()
-
-			// calculate VMAF score
-			var vmafScore float64
-			wg.Add(1)
-			go func() {
-				var vmafErr error
-				vmafScore, vmafErr = vmaf.CalculateVMAF(cancelCtx, uint64(i-1), curWidth, curHeight)
-				if vmafErr != nil {
-					fmt.Printf("Error encountered calculating vmaf:\n%v\n", vmafErr)
-					errc <- err
-				} else if vmafScore < lowVMAFThreshold {
-					errc <- fmt.Errorf("Low vmaf score detected, most likely due to misconfiguration. Score %f is below threshold %f\n", vmafScore, lowVMAFThreshold)
-				} else {
-					fmt.Printf("I calculated vmaf and got this harmonic mean: %f\n", vmafScore)
-				}
-
-				wg.Done()
-			}()
-
-			go func() {
-				wg.Wait()
-				close(errc)
-			}()
-
-			hadErr := false
-			for err := range errc {
-				if err != nil && !hadErr {
-					hadErr = true
-					cancelFunc()
-					fmt.Printf("Error encountered running VMAF: %v\n", err)
-				}
+	// spread describes how consistent VMAF was across the frames of a pair
+	spread := func(score ResolutionScore) string {
+		if metric != "" {
+			return ""
+		}
+		return fmt.Sprintf(" (stddev %s, p5 %s, p95 %s, min %s)", formatScore(score.VMAFStdDev, *resultPrecision), formatScore(score.VMAFP5, *resultPrecision), formatScore(score.VMAFP95, *resultPrecision), formatScore(score.VMAFMin, *resultPrecision))
+	}
+	if report.CompareResolution == "" && report.Partial {
+		for _, score := range report.Scores {
+			fmt.Printf("Variant %d (%d bps) %s at %dx%d: %s%s\n", score.Variant, report.Variants[score.Variant].Bandwidth, scoreLabel, score.Width, score.Height, formatScore(score.VMAF, *resultPrecision), spread(score))
+		}
+		fmt.Printf("Partial run, unscored pairs count as 0 in the average %s of %s\n", scoreLabel, formatScore(report.AverageVMAF, *resultPrecision))
+	} else if report.CompareResolution == "" {
+		fmt.Printf("Average %s: %s\n", scoreLabel, formatScore(report.AverageVMAF, *resultPrecision))
+	} else {
+		for _, score := range report.Scores {
+			fmt.Printf("Variant %d (%d bps) %s at %dx%d: %s%s\n", score.Variant, report.Variants[score.Variant].Bandwidth, scoreLabel, score.Width, score.Height, formatScore(score.VMAF, *resultPrecision), spread(score))
+		}
+	}
+
+	// VMAF per Mbps of each rendition, flagging those spending bits their neighbors don't
+	if curve := ladderCurve(report); len(curve) > 0 {
+		report.Efficiency = ladderEfficiency(curve, efficiencyRule)
+		fmt.Printf("%s per Mbps at each rendition's native resolution:\n", scoreLabel)
+		for _, rendition := range report.Efficiency.Renditions {
+			note := ""
+			if rendition.Outlier && efficiencyRule.ZScore {
+				note = fmt.Sprintf(" (outlier, z-score %.2f against the ladder's trend)", rendition.ZScore)
+			} else if rendition.Outlier {
+				note = fmt.Sprintf(" (outlier, %.2f of its neighbors' efficiency)", rendition.NeighborRatio)
 			}
+			fmt.Printf("  variant %d (%d bps): %.2f%s\n", rendition.Variant, rendition.Bandwidth, rendition.VMAFPerMbps, note)
+		}
+	}
 
-			if hadErr {
-				fmt.Printf("Error running vmaf calculation, goodbye\n")
-				return
+	if comparison := report.ReferenceLadder; comparison != nil {
+		fmt.Printf("Compared to the reference ladder %q:\n", comparison.Reference)
+		for _, rendition := range comparison.Renditions {
+			note := ""
+			if rendition.OutOfRange {
+				note = " (outside the reference ladder's bandwidths)"
 			}
-			fmt.Println("Oh yeah decode done\n")
+			fmt.Printf("  variant %d (%d bps) %s vs %s: %+.*f%s\n", rendition.Variant, rendition.Bandwidth, formatScore(rendition.VMAF, *resultPrecision), formatScore(rendition.ReferenceVMAF, *resultPrecision), *resultPrecision, rendition.Delta, note)
+		}
+		verdict := comparison.Verdict + " than"
+		if comparison.Verdict == "equivalent" {
+			verdict = "equivalent to"
+		}
+		fmt.Printf("Mean delta %+.*f, the ladder is %s the reference\n", *resultPrecision, comparison.MeanDelta, verdict)
+	}
 
-			// fill in and print effective VMAF score
-			effectiveVmafs[i][j] = vmafScore
-			fmt.Printf("%f%% of users have the bitrate to watch this rendition\n", userPcts[i])
-			fmt.Printf("Of those, %f%% will be watching at the current resolution of %dx%d\n", resUserPct, curWidth, curHeight)
+	// score the other ladder the same way, for the bitrate it saves at equal VMAF
+	if *compareManifest != "" {
+		compareCfg := cfg
+		compareCfg.ManifestURL = *compareManifest
+		compareCfg.Ladder = ""
+		compareCfg.ReferenceLadder = ""
+		compareCfg.DumpDir = filepath.Join(cfg.DumpDir, "compare")
+		compareCfg.RunID = filepath.Base(report.LogsDir) + "-compare"
+		slog.Info("Scoring the comparison ladder", "manifest", *compareManifest)
+		compareReport, compareErr := Analyze(ctx, compareCfg)
+		if code, ok := stopped(compareErr); ok {
+			return code
+		}
+		if compareReport == nil {
+			slog.Error("Comparison ladder analysis failed", "error", compareErr)
+			return exitCode(compareErr)
+		}
+		comparison, err := bdRate(ladderCurve(report), ladderCurve(compareReport))
+		if err != nil {
+			slog.Error("Failed to compute BD-rate", "error", err)
+			return exitFailure
+		}
+		comparison.Ladder = *compareManifest
+		report.BDRate = comparison
+		savings := "more"
+		if comparison.BDRate < 0 {
+			savings = "less"
 		}
+		fmt.Printf("BD-rate of %q: %+.2f%%, it needs %.2f%% %s bitrate for the same VMAF between %s and %s\n", *compareManifest, comparison.BDRate, math.Abs(comparison.BDRate), savings, formatScore(comparison.MinVMAF, *resultPrecision), formatScore(comparison.MaxVMAF, *resultPrecision))
 	}
 
-	// calculate acg VMAF score and print
-	totalVmaf := float64(0.0)
-	for i, bitratePct := range userPcts {
-		for j, resPct := range data.ResolutionPcts {
-			totalVmaf += effectiveVmafs[i][j] * bitratePct * resPct
+	asset := filepath.Base(mezzanineFile)
+	if relative {
+		asset = manifestURL
+		if *ladderFile != "" {
+			asset = *ladderFile
 		}
 	}
-	fmt.Printf("Average VMAF: %f\n", totalVmaf)
+	if !renderOutputs(report, asset) {
+		return exitFailure
+	}
+
+	// fail the run once every output is written when the quality gate was missed
+	if err != nil {
+		slog.Error("Quality gate failed", "error", err)
+		return exitCode(err)
+	}
+	return exitOK
 }
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/grafov/m3u8"
+)
+
+func TestBandwidthUserPctsAboveTenMbps(t *testing.T) {
+	variants := []*m3u8.Variant{
+		{VariantParams: m3u8.VariantParams{Bandwidth: 5000000}},
+		{VariantParams: m3u8.VariantParams{Bandwidth: 10000000}},
+		{VariantParams: m3u8.VariantParams{Bandwidth: 15000000}},
+	}
+	// 200 buckets of 100kbps reach 20Mbps, with users spread evenly over them
+	bandwidthPcts := make([]float64, 200)
+	for i := range bandwidthPcts {
+		bandwidthPcts[i] = 1.0 / 200
+	}
+
+	// the default 100 buckets stop at 10Mbps, leaving the 10 and 15Mbps variants no users
+	for buckets, want := range map[int][]float64{
+		200: {0.25, 0.25, 0.25, 0.25},
+		100: {0.25, 0.25, 0, 0},
+	} {
+		got := bandwidthUserPcts(bandwidthPcts[:buckets], 100*1000, variants)
+		if len(got) != len(want) {
+			t.Fatalf("%d buckets: got %d user shares, want %d", buckets, len(got), len(want))
+		}
+		for i := range want {
+			if math.Abs(got[i]-want[i]) > 1e-9 {
+				t.Errorf("%d buckets: user share %d = %f, want %f", buckets, i, got[i], want[i])
+			}
+		}
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"success", nil, exitOK},
+		{"probe failure", &StageError{StageProbe, fmt.Errorf("no video stream")}, exitProbe},
+		{"vmaf failure", &StageError{StageVMAF, fmt.Errorf("vmafossexec crashed")}, exitVMAF},
+		{"other stage", &StageError{"dump", fmt.Errorf("404")}, exitFailure},
+		{"low score", &LowScoreError{MinVMAF: 80, Low: []string{"variant 0"}}, exitFailure},
+		{"aborted low score", &LowScoreError{MinVMAF: 80, Low: []string{"variant 0"}, Aborted: true}, exitFailure},
+		{"generic", fmt.Errorf("Failed to load master manifest"), exitFailure},
+	}
+	for _, test := range tests {
+		if got := exitCode(test.err); got != test.want {
+			t.Errorf("%s: exitCode(%v) = %d, want %d", test.name, test.err, got, test.want)
+		}
+	}
+}
+
+func TestRunUsageError(t *testing.T) {
+	if err := flag.Set("reference-from-variant", "bottom"); err != nil {
+		t.Fatal(err)
+	}
+	defer flag.Set("reference-from-variant", "")
+	if got := run(); got != exitUsage {
+		t.Errorf("run() with an unknown --reference-from-variant = %d, want %d", got, exitUsage)
+	}
+}
+
+func TestWidthToHeightAnamorphic(t *testing.T) {
+	// PAL 4:3 stored as 720x576 with 16:15 pixels displays as 768x576
+	tests := []struct {
+		name      string
+		mezzanine *FFProbeStream
+		width     uint64
+		want      uint64
+	}{
+		{"sar", &FFProbeStream{Width: 720, Height: 576, SampleAspectRatio: "16:15"}, 720, 540},
+		{"sar at display width", &FFProbeStream{Width: 720, Height: 576, SampleAspectRatio: "16:15"}, 768, 576},
+		{"dar only", &FFProbeStream{Width: 720, Height: 576, DisplayAspectRatio: "4:3"}, 720, 540},
+		{"square pixels", &FFProbeStream{Width: 1920, Height: 1080, SampleAspectRatio: "1:1"}, 1280, 720},
+		{"unknown sar", &FFProbeStream{Width: 1920, Height: 1080, SampleAspectRatio: "0:1"}, 1280, 720},
+	}
+	for _, test := range tests {
+		if got := widthToHeight(test.width, test.mezzanine); got != test.want {
+			t.Errorf("%s: widthToHeight(%d) = %d, want %d", test.name, test.width, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/grafov/m3u8"
+)
+
+// resolveURI resolves a playlist URI against the URL of the playlist that referenced it
+func resolveURI(base, ref string) (string, error) {
+	baseURL, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+	refURL, err := url.Parse(ref)
+	if err != nil {
+		return "", err
+	}
+	return baseURL.ResolveReference(refURL).String(), nil
+}
+
+// resolveVariantURIs makes the variant URIs of a master playlist absolute, so ffmpeg doesn't look
+// for relative ones in the working directory. Variants of a local manifest become paths on disk.
+func resolveVariantURIs(manifestURL string, variants []*m3u8.Variant) error {
+	for _, variant := range variants {
+		resolved, err := resolveURI(manifestURL, variant.URI)
+		if err != nil {
+			return fmt.Errorf("Invalid variant URI %q: %v", variant.URI, err)
+		}
+		if path, ok := localPath(resolved); ok {
+			resolved = path
+		}
+		variant.URI = resolved
+	}
+	return nil
+}
+
+// manifestLocation turns a manifest argument naming a local file into a file:// URL, leaving URLs
+// as they are
+func manifestLocation(arg string) (string, error) {
+	if strings.HasPrefix(arg, "file://") {
+		return arg, nil
+	}
+	if _, err := os.Stat(arg); err != nil {
+		return arg, nil
+	}
+	abs, err := filepath.Abs(arg)
+	if err != nil {
+		return "", err
+	}
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(abs)}).String(), nil
+}
+
+// localPath returns the path on disk of a file:// URL
+func localPath(rawURL string) (string, bool) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Scheme != "file" {
+		return "", false
+	}
+	return filepath.FromSlash(parsed.Path), true
+}
+
+// httpRetryBaseDelay is the wait before the first retry, doubling on each retry after it
+const httpRetryBaseDelay = 500 * time.Millisecond
+
+// HTTPFetcher fetches manifests and segments with the origin's headers, retrying transient failures
+type HTTPFetcher struct {
+	Client  *http.Client
+	Headers http.Header
+	// Netrc adds the Authorization of each request's host, nil when no netrc file is used
+	Netrc *NetrcAuth
+	// Retries is how many times network errors and 5xx responses are retried
+	Retries int
+}
+
+// retryWithBackoff calls attempt until it succeeds, fails with an error that isn't retryable or
+// runs out of retries, backing off exponentially in between. Cancelling ctx stops it straight away.
+func retryWithBackoff(ctx context.Context, retries int, attempt func() (retryable bool, err error)) error {
+	delay := httpRetryBaseDelay
+	for try := 0; ; try++ {
+		retryable, err := attempt()
+		if err == nil || !retryable || try >= retries || ctx.Err() != nil {
+			return err
+		}
+		slog.Warn("Retrying", "delay", delay, "attempt", try+1, "attempts", retries+1, "error", err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		delay *= 2
+	}
+}
+
+// fetchURL issues a GET for the URL, returning the body of a successful response. file:// URLs
+// are read straight from disk.
+func fetchURL(ctx context.Context, rawURL string, fetcher *HTTPFetcher) (io.ReadCloser, error) {
+	if path, ok := localPath(rawURL); ok {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to open %s: %v", path, err)
+		}
+		return f, nil
+	}
+
+	client := fetcher.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	headers, err := fetcher.Netrc.apply(fetcher.Headers, rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	var body io.ReadCloser
+	err = retryWithBackoff(ctx, fetcher.Retries, func() (bool, error) {
+		req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+		if err != nil {
+			return false, fmt.Errorf("Failed to build request (%s): %v", rawURL, err)
+		}
+		req.Header = headers
+		resp, err := client.Do(req.WithContext(ctx))
+		if err != nil {
+			return true, fmt.Errorf("Failed to fetch %s: %v", rawURL, err)
+		}
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			resp.Body.Close()
+			return resp.StatusCode >= 500, fmt.Errorf("Failed to fetch %s: %s", rawURL, resp.Status)
+		}
+		body = resp.Body
+		return false, nil
+	})
+	return body, err
+}
+
+// fetchPlaylist retrieves and decodes an HLS playlist
+func fetchPlaylist(ctx context.Context, playlistURL string, fetcher *HTTPFetcher) (m3u8.Playlist, m3u8.ListType, error) {
+	body, err := fetchURL(ctx, playlistURL, fetcher)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer body.Close()
+
+	playlist, listType, err := m3u8.DecodeFrom(body, false)
+	if err != nil {
+		return nil, 0, fmt.Errorf("Failed to decode playlist (%s): %v", playlistURL, err)
+	}
+	return playlist, listType, nil
+}
+
+// Containers variants are dumped to, matching the container of their segments so -c copy works
+const (
+	dumpContainerTS  = ".ts"
+	dumpContainerMP4 = ".mp4"
+)
+
+// fmp4SegmentExts are the extensions of fMP4 and CMAF media segments
+var fmp4SegmentExts = map[string]bool{".m4s": true, ".mp4": true, ".m4v": true, ".cmfv": true}
+
+// segmentContainer picks the container a media playlist's segments are dumped to. fMP4 playlists
+// carry an EXT-X-MAP init segment, failing that the segment extensions give them away.
+func segmentContainer(playlist *m3u8.MediaPlaylist) string {
+	if playlist.Map != nil {
+		return dumpContainerMP4
+	}
+	for _, segment := range playlist.Segments {
+		if segment == nil {
+			break
+		}
+		if segment.Map != nil {
+			return dumpContainerMP4
+		}
+		uri := segment.URI
+		if u, err := url.Parse(uri); err == nil {
+			uri = u.Path
+		}
+		if fmp4SegmentExts[strings.ToLower(path.Ext(uri))] {
+			return dumpContainerMP4
+		}
+		break
+	}
+	return dumpContainerTS
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/grafov/m3u8"
+)
+
+func TestResolveVariantURIs(t *testing.T) {
+	tests := []struct {
+		manifest string
+		uri      string
+		want     string
+	}{
+		{"https://cdn.example.com/live/master.m3u8", "v0/stream.m3u8", "https://cdn.example.com/live/v0/stream.m3u8"},
+		{"https://cdn.example.com/live/master.m3u8", "../vod/v1.m3u8", "https://cdn.example.com/vod/v1.m3u8"},
+		{"https://cdn.example.com/live/master.m3u8", "/v2/stream.m3u8", "https://cdn.example.com/v2/stream.m3u8"},
+		{"https://cdn.example.com/live/master.m3u8", "https://other.example.com/v3.m3u8", "https://other.example.com/v3.m3u8"},
+		{"file:///media/master.m3u8", "v0/stream.m3u8", "/media/v0/stream.m3u8"},
+	}
+	for _, test := range tests {
+		variants := []*m3u8.Variant{{URI: test.uri}}
+		if err := resolveVariantURIs(test.manifest, variants); err != nil {
+			t.Fatal(err)
+		}
+		if variants[0].URI != test.want {
+			t.Errorf("%s in %s resolved to %s, want %s", test.uri, test.manifest, variants[0].URI, test.want)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// mezzanineCache keeps the mezzanine decoded to raw yuv at each resolution it's scored at, as the
+// same frames would otherwise be decoded again for every variant. It trades disk for CPU: a 1080p
+// yuv420p frame is about 3MB, twice that at 10-bit.
+type mezzanineCache struct {
+	dir         string
+	decoder     Decoder
+	pixelFormat PixelFormat
+
+	// pairs scored concurrently wait on each other's decodes rather than decoding twice
+	mu      sync.Mutex
+	pending map[string]*sync.Mutex
+}
+
+// newMezzanineCache creates a directory for the run's decodes inside parent, which the caller
+// removes with cleanup once the run is done
+func newMezzanineCache(parent string, decoder Decoder, pixelFormat PixelFormat) (*mezzanineCache, error) {
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return nil, err
+	}
+	dir, err := ioutil.TempDir(parent, "mezzanine")
+	if err != nil {
+		return nil, err
+	}
+	return &mezzanineCache{dir: dir, decoder: decoder, pixelFormat: pixelFormat, pending: map[string]*sync.Mutex{}}, nil
+}
+
+func (c *mezzanineCache) path(width, height, start, end uint64) string {
+	return filepath.Join(c.dir, fmt.Sprintf("%dx%d_%d_%d.yuv", width, height, start, end))
+}
+
+// stream writes frames [start, end) of input decoded to widthxheight into the FIFO at fifo,
+// decoding them first unless an earlier variant already has. An end of 0 runs to the last frame.
+func (c *mezzanineCache) stream(ctx context.Context, input, fifo string, width, height, start, end uint64, pipeSize int) error {
+	cached := c.path(width, height, start, end)
+	if err := c.fill(ctx, input, cached, width, height, start, end); err != nil {
+		return err
+	}
+
+	return streamYUV(ctx, cached, fifo, c.pixelFormat.FrameSize(width, height), 0, 0, pipeSize)
+}
+
+// fill decodes frames [start, end) of input to the cached path unless they already have been
+func (c *mezzanineCache) fill(ctx context.Context, input, cached string, width, height, start, end uint64) error {
+	c.mu.Lock()
+	lock, ok := c.pending[cached]
+	if !ok {
+		lock = &sync.Mutex{}
+		c.pending[cached] = lock
+	}
+	c.mu.Unlock()
+	lock.Lock()
+	defer lock.Unlock()
+
+	_, err := os.Stat(cached)
+	if err == nil {
+		slog.Info("Reusing the cached mezzanine decode", resolution(width, height), "path", cached)
+		return nil
+	}
+	if !os.IsNotExist(err) {
+		return fmt.Errorf("Failed to check the cached mezzanine decode: %v", err)
+	}
+	slog.Info("Caching the mezzanine decode", resolution(width, height), "path", cached)
+	partial := cached + ".partial"
+	if err := c.decoder.DecodeFrameRangeToWidthAndHeight(ctx, input, partial, width, height, start, end); err != nil {
+		os.Remove(partial)
+		return err
+	}
+	// a decode in another format than VMAF reads would be scored misaligned
+	info, err := os.Stat(partial)
+	if err != nil {
+		return fmt.Errorf("Failed to cache the mezzanine decode: %v", err)
+	}
+	if frameSize := c.pixelFormat.FrameSize(width, height); uint64(info.Size())%frameSize != 0 {
+		os.Remove(partial)
+		return fmt.Errorf("Cached mezzanine decode of %d bytes isn't a whole number of %dx%d %s frames of %d bytes", info.Size(), width, height, c.pixelFormat.Name, frameSize)
+	}
+	if err := os.Rename(partial, cached); err != nil {
+		return fmt.Errorf("Failed to cache the mezzanine decode: %v", err)
+	}
+	return nil
+}
+
+// cleanup removes the run's cached decodes
+func (c *mezzanineCache) cleanup() {
+	if err := os.RemoveAll(c.dir); err != nil {
+		slog.Error("Failed to remove the mezzanine cache", "path", c.dir, "error", err)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeDecodeFFmpeg records its arguments and writes two 64x64 yuv420p frames to its output,
+// failing like ffmpeg does when it isn't told the output format
+const fakeDecodeFFmpeg = recordArgs + `for out in "$@"; do :; done
+case " $* " in
+*" -f rawvideo $out "*) head -c 12288 /dev/zero > "$out" ;;
+*) echo "Unable to choose an output format for '$out'" >&2; exit 1 ;;
+esac
+`
+
+func TestMezzanineCacheStreamsThroughFFmpeg(t *testing.T) {
+	argsFile := filepath.Join(t.TempDir(), "args")
+	t.Setenv("ARGS_FILE", argsFile)
+	f := NewFFmpegDecoder()
+	f.FFmpegPath = fakeBinary(t, "ffmpeg", fakeDecodeFFmpeg)
+
+	cache, err := newMezzanineCache(t.TempDir(), f, DefaultPixelFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.cleanup()
+
+	for i := 0; i < 2; i++ {
+		out := filepath.Join(t.TempDir(), "mezzanine.yuv")
+		if err := ioutil.WriteFile(out, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := cache.stream(context.Background(), "mezzanine.mp4", out, 64, 64, 0, 2, 0); err != nil {
+			t.Fatalf("stream %d: %v", i, err)
+		}
+		if info, err := os.Stat(out); err != nil {
+			t.Fatal(err)
+		} else if info.Size() != 12288 {
+			t.Errorf("stream %d wrote %d bytes, want both frames", i, info.Size())
+		}
+		// the second stream reads the cached decode, leaving ffmpeg's arguments from the first
+		if i == 0 {
+			if err := os.Remove(argsFile); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if _, err := os.Stat(argsFile); !os.IsNotExist(err) {
+		t.Errorf("ffmpeg ran again for the cached decode")
+	}
+}
+
+func TestMezzanineCacheFillReportsStatErrors(t *testing.T) {
+	cache := &mezzanineCache{dir: t.TempDir(), pixelFormat: DefaultPixelFormat, pending: map[string]*sync.Mutex{}}
+	// a path below a regular file can't be stat'd, which isn't a cache hit
+	blocker := filepath.Join(cache.dir, "file")
+	if err := ioutil.WriteFile(blocker, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	err := cache.fill(context.Background(), "mezzanine.mp4", filepath.Join(blocker, "64x64_0_2.yuv"), 64, 64, 0, 2)
+	if err == nil || !strings.Contains(err.Error(), "Failed to check") {
+		t.Errorf("fill() = %v, want the stat error", err)
+	}
+}
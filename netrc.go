@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// NetrcCredentials are the login details of a single netrc machine entry
+type NetrcCredentials struct {
+	Login    string
+	Password string
+}
+
+// netrcPath picks the netrc file to read: an explicit path wins, then $NETRC, then ~/.netrc
+func netrcPath(explicit string) string {
+	if explicit != "" {
+		return explicit
+	}
+	if env := os.Getenv("NETRC"); env != "" {
+		return env
+	}
+	if home := os.Getenv("HOME"); home != "" {
+		return filepath.Join(home, ".netrc")
+	}
+	return ""
+}
+
+// NetrcAuth looks up the Basic Authorization of each host a request goes to in a netrc file, so
+// credentials are only ever sent to the machine they were written for
+type NetrcAuth struct {
+	Path string
+
+	mu    sync.Mutex
+	hosts map[string]string
+}
+
+// Authorization is the Basic Authorization header for host, empty when the file has no entry for it
+func (n *NetrcAuth) Authorization(host string) (string, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if auth, ok := n.hosts[host]; ok {
+		return auth, nil
+	}
+	creds, err := loadNetrcCredentials(n.Path, host)
+	if err != nil {
+		return "", err
+	}
+	var auth string
+	if creds != nil {
+		slog.Info("Using netrc credentials", "netrc", n.Path, "host", host)
+		auth = "Basic " + base64.StdEncoding.EncodeToString([]byte(creds.Login+":"+creds.Password))
+	}
+	if n.hosts == nil {
+		n.hosts = map[string]string{}
+	}
+	n.hosts[host] = auth
+	return auth, nil
+}
+
+// apply returns headers with the Authorization for rawURL's host added, leaving headers that
+// already carry one untouched. A nil NetrcAuth adds nothing.
+func (n *NetrcAuth) apply(headers http.Header, rawURL string) (http.Header, error) {
+	if n == nil || headers.Get("Authorization") != "" {
+		return headers, nil
+	}
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid URL %q: %v", rawURL, err)
+	}
+	auth, err := n.Authorization(parsed.Hostname())
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read netrc file %q: %v", n.Path, err)
+	}
+	if auth == "" {
+		return headers, nil
+	}
+	headers = headers.Clone()
+	if headers == nil {
+		headers = http.Header{}
+	}
+	headers.Set("Authorization", auth)
+	return headers, nil
+}
+
+// loadNetrcCredentials looks up the credentials for host in the netrc file at path,
+// returning nil when there is no matching machine or default entry
+func loadNetrcCredentials(path, host string) (*NetrcCredentials, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseNetrc(f, host)
+}
+
+func parseNetrc(r io.Reader, host string) (*NetrcCredentials, error) {
+	var (
+		matched  *NetrcCredentials
+		fallback *NetrcCredentials
+		current  *NetrcCredentials
+		inMacro  bool
+	)
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		// macro definitions run until the next blank line
+		if inMacro {
+			if strings.TrimSpace(line) == "" {
+				inMacro = false
+			}
+			continue
+		}
+
+		fields := strings.Fields(line)
+		for i := 0; i < len(fields); i++ {
+			field := fields[i]
+			if strings.HasPrefix(field, "#") {
+				break
+			}
+
+			switch field {
+			case "machine":
+				if i+1 >= len(fields) {
+					return nil, fmt.Errorf("netrc: machine without a name")
+				}
+				i++
+				current = &NetrcCredentials{}
+				if fields[i] == host && matched == nil {
+					matched = current
+				}
+			case "default":
+				current = &NetrcCredentials{}
+				if fallback == nil {
+					fallback = current
+				}
+			case "login", "password", "account":
+				if i+1 >= len(fields) {
+					return nil, fmt.Errorf("netrc: %s without a value", field)
+				}
+				i++
+				if current == nil {
+					return nil, fmt.Errorf("netrc: %s outside of a machine entry", field)
+				}
+				if field == "login" {
+					current.Login = fields[i]
+				} else if field == "password" {
+					current.Password = fields[i]
+				}
+			case "macdef":
+				inMacro = true
+				i = len(fields)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if matched != nil {
+		return matched, nil
+	}
+	return fallback, nil
+}
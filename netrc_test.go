@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeNetrc(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "netrc")
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestNetrcAuthOnlyAppliesToMatchingHost(t *testing.T) {
+	netrc := &NetrcAuth{Path: writeNetrc(t, "machine origin.example.com login user password secret\n")}
+
+	headers, err := netrc.apply(http.Header{"X-Test": {"1"}}, "https://origin.example.com/master.m3u8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := headers.Get("Authorization"), "Basic dXNlcjpzZWNyZXQ="; got != want {
+		t.Errorf("origin Authorization = %q, want %q", got, want)
+	}
+
+	headers, err = netrc.apply(http.Header{"X-Test": {"1"}}, "https://cdn.example.net/variant.m3u8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := headers.Get("Authorization"); got != "" {
+		t.Errorf("other host Authorization = %q, want none", got)
+	}
+	if got := headers.Get("X-Test"); got != "1" {
+		t.Errorf("other host lost its headers, X-Test = %q", got)
+	}
+
+	explicit := http.Header{"Authorization": {"Bearer token"}}
+	if headers, _ = netrc.apply(explicit, "https://origin.example.com/master.m3u8"); headers.Get("Authorization") != "Bearer token" {
+		t.Errorf("Authorization header replaced by netrc, got %q", headers.Get("Authorization"))
+	}
+}
+
+func TestFetchURLSendsNetrcOnlyToItsHost(t *testing.T) {
+	var auths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auths = append(auths, r.Header.Get("Authorization"))
+	}))
+	defer server.Close()
+
+	fetch := func(netrc string) {
+		fetcher := &HTTPFetcher{Headers: http.Header{}, Netrc: &NetrcAuth{Path: writeNetrc(t, netrc)}}
+		body, err := fetchURL(context.Background(), server.URL+"/media.m3u8", fetcher)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body.Close()
+	}
+	fetch("machine origin.example.com login user password secret\n")
+	fetch("machine 127.0.0.1 login user password secret\n")
+
+	if len(auths) != 2 || auths[0] != "" || auths[1] != "Basic dXNlcjpzZWNyZXQ=" {
+		t.Errorf("Authorization headers = %q, want none then the 127.0.0.1 login", auths)
+	}
+}
+
+func TestDumpStreamSendsNetrcOnlyToItsHost(t *testing.T) {
+	netrc := &NetrcAuth{Path: writeNetrc(t, "machine origin.example.com login user password secret\n")}
+	for url, want := range map[string]bool{
+		"https://origin.example.com/v0.m3u8": true,
+		"https://cdn.example.net/v0.m3u8":    false,
+	} {
+		argsFile := filepath.Join(t.TempDir(), "args")
+		t.Setenv("ARGS_FILE", argsFile)
+		f := NewFFmpegDecoder()
+		f.FFmpegPath = fakeBinary(t, "ffmpeg", recordArgs)
+		f.FFprobePath = fakeBinary(t, "ffprobe", fakeFFprobe)
+		f.Headers = http.Header{"X-Test": {"1"}}
+		f.Netrc = netrc
+		if _, err := f.DumpStream(context.Background(), url, filepath.Join(t.TempDir(), "variant_0.ts")); err != nil {
+			t.Fatal(err)
+		}
+		// the CRLF separated -headers span several recorded lines
+		args := strings.Join(recordedArgs(t, argsFile), "\n")
+		if !strings.Contains(args, "X-Test: 1") {
+			t.Errorf("%s: args %q lost the configured headers", url, args)
+		}
+		if got := strings.Contains(args, "Authorization: Basic dXNlcjpzZWNyZXQ="); got != want {
+			t.Errorf("%s: args %q, want credentials %v", url, args, want)
+		}
+	}
+}
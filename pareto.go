@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+)
+
+// ParetoPoint is a single rendition scored at its native resolution
+type ParetoPoint struct {
+	Variant     int     `json:"variant"`
+	Bandwidth   uint32  `json:"bandwidth"`
+	Width       uint64  `json:"width"`
+	Height      uint64  `json:"height"`
+	VMAF        float64 `json:"vmaf"`
+	DominatedBy *int    `json:"dominated_by,omitempty"`
+}
+
+// ParetoReport splits a ladder into its bandwidth vs VMAF Pareto frontier and
+// the renditions dominated by some other rendition
+type ParetoReport struct {
+	Frontier  []*ParetoPoint `json:"frontier"`
+	Dominated []*ParetoPoint `json:"dominated"`
+}
+
+// dominates reports whether a is at least as good as b on both axes and strictly better on one
+func dominates(a, b *ParetoPoint) bool {
+	if a.Bandwidth > b.Bandwidth || a.VMAF < b.VMAF {
+		return false
+	}
+	return a.Bandwidth < b.Bandwidth || a.VMAF > b.VMAF
+}
+
+// paretoFrontier computes the Pareto-optimal set of renditions, expects points sorted by bandwidth
+func paretoFrontier(points []*ParetoPoint) *ParetoReport {
+	report := &ParetoReport{
+		Frontier:  []*ParetoPoint{},
+		Dominated: []*ParetoPoint{},
+	}
+	for _, p := range points {
+		for _, q := range points {
+			if q != p && dominates(q, p) {
+				dominator := q.Variant
+				p.DominatedBy = &dominator
+				break
+			}
+		}
+
+		if p.DominatedBy == nil {
+			report.Frontier = append(report.Frontier, p)
+		} else {
+			report.Dominated = append(report.Dominated, p)
+		}
+	}
+	return report
+}
+
+func writeParetoReport(path string, report *ParetoReport) error {
+	out, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, out, 0644)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"syscall"
+	"time"
+)
+
+// decodeWatchdogGrace is how long decodes may outlive the VMAF run reading them before they're
+// taken to be hung on their FIFOs
+const decodeWatchdogGrace = 30 * time.Second
+
+// watchDecodes waits for the decodes feeding a VMAF run that has exited. Decodes still running after
+// decodeWatchdogGrace have lost their reader, so their FIFOs are drained until decoded is closed and
+// an error is returned for the caller to cancel them with.
+func watchDecodes(decoded <-chan struct{}, fifos ...string) error {
+	select {
+	case <-decoded:
+		return nil
+	case <-time.After(decodeWatchdogGrace):
+	}
+	slog.Warn("Decodes are still running after VMAF exited, killing them", "grace", decodeWatchdogGrace)
+	for _, fifo := range fifos {
+		go drainFifo(fifo, decoded)
+	}
+	return fmt.Errorf("Decodes were still running %v after VMAF exited", decodeWatchdogGrace)
+}
+
+// drainFifo reads and discards whatever is written to the FIFO at path until stop is closed, so
+// writers blocked on opening or writing to it can run to their exit
+func drainFifo(path string, stop <-chan struct{}) {
+	f, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return
+	}
+	go func() {
+		<-stop
+		f.Close()
+	}()
+	buf := make([]byte, 64*1024)
+	for {
+		if _, err := f.Read(buf); err != nil {
+			// there's nothing to read until the next writer opens it
+			select {
+			case <-stop:
+				return
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}
+}
+
+// openFifoWriter opens the write end of the FIFO at path and grows its buffer to size bytes,
+// leaving it at the system default when size is 0.
+// A FIFO's buffer only lives as long as some process holds it open, so the resize has to be
+// made on the descriptor the decoder then writes through rather than straight after Mkfifo.
+// Opening blocks until the reader arrives, unless ctx is cancelled first.
+func openFifoWriter(ctx context.Context, path string, size int) (*os.File, error) {
+	type openResult struct {
+		f   *os.File
+		err error
+	}
+	opened := make(chan openResult, 1)
+	go func() {
+		f, err := os.OpenFile(path, os.O_WRONLY, 0)
+		opened <- openResult{f, err}
+	}()
+
+	var result openResult
+	select {
+	case result = <-opened:
+	case <-ctx.Done():
+		// stand in as the reader so the pending open returns
+		if reader, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NONBLOCK, 0); err == nil {
+			reader.Close()
+		}
+		if result = <-opened; result.f != nil {
+			result.f.Close()
+		}
+		return nil, ctx.Err()
+	}
+	if result.err != nil {
+		return nil, result.err
+	}
+
+	if size == 0 {
+		return result.f, nil
+	}
+	if err := setPipeSize(result.f, size); err != nil {
+		slog.Warn("Unable to set the pipe buffer size, using the system default", "path", path, "bytes", size, "error", err)
+	}
+	return result.f, nil
+}
@@ -0,0 +1,32 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// fSetPipeSize is F_SETPIPE_SZ, which the syscall package doesn't define
+const fSetPipeSize = 1031
+
+func setPipeSize(f *os.File, size int) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_FCNTL, f.Fd(), fSetPipeSize, uintptr(size))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// maxPipeSize is the largest buffer an unprivileged process may give a pipe
+func maxPipeSize() (int, error) {
+	raw, err := ioutil.ReadFile("/proc/sys/fs/pipe-max-size")
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(raw)))
+}
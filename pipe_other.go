@@ -0,0 +1,17 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func setPipeSize(f *os.File, size int) error {
+	return fmt.Errorf("pipe buffer sizing is only supported on linux")
+}
+
+func maxPipeSize() (int, error) {
+	return 0, fmt.Errorf("pipe buffer sizing is only supported on linux")
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// scoreJob is a variant and resolution pair to score
+type scoreJob struct {
+	variant int
+	width   uint64
+	height  uint64
+}
+
+// scoreOutcome is the result of scoring a scoreJob
+type scoreOutcome struct {
+	result *VMAFResult
+	// identical is set when VMAF was skipped because the variant is a lossless copy of the reference
+	identical bool
+	segments  []SegmentScore
+	elapsed   time.Duration
+	err       error
+	slot      *vmafSlot
+}
+
+// vmafSlot is a pair of decode FIFOs and the estimator reading them. Each pair scored at the same
+// time gets its own slot, so a VMAF run only ever reads the decodes of its own resolution.
+type vmafSlot struct {
+	mezzaninePath string
+	distortedPath string
+	vmaf          Estimator
+}
+
+// slotPath names a FIFO of the given slot, the first slot keeps the plain name
+func slotPath(path string, slot int) string {
+	if slot == 0 {
+		return path
+	}
+	ext := filepath.Ext(path)
+	return fmt.Sprintf("%s.%d%s", strings.TrimSuffix(path, ext), slot, ext)
+}
+
+// runPipeline scores up to one job per slot at a time, so the decodes of the next pair overlap
+// VMAF of the current one. Outcomes are handed to done in job order and a slot is only reused
+// once done has seen its outcome, bounding the work in flight. An error from done stops the
+// pipeline, cancelling the jobs still running.
+func runPipeline(ctx context.Context, jobs []scoreJob, slots []*vmafSlot, score func(context.Context, *vmafSlot, scoreJob) scoreOutcome, done func(scoreJob, scoreOutcome) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	free := make(chan *vmafSlot, len(slots))
+	for _, slot := range slots {
+		free <- slot
+	}
+	outcomes := make([]chan scoreOutcome, len(jobs))
+	for i := range outcomes {
+		outcomes[i] = make(chan scoreOutcome, 1)
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i, job := range jobs {
+			var slot *vmafSlot
+			select {
+			case slot = <-free:
+			case <-ctx.Done():
+				return
+			}
+			wg.Add(1)
+			go func(i int, job scoreJob, slot *vmafSlot) {
+				defer wg.Done()
+				outcome := score(ctx, slot, job)
+				outcome.slot = slot
+				outcomes[i] <- outcome
+			}(i, job, slot)
+		}
+	}()
+
+	for i, job := range jobs {
+		var outcome scoreOutcome
+		select {
+		case outcome = <-outcomes[i]:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if err := done(job, outcome); err != nil {
+			return err
+		}
+		free <- outcome.slot
+	}
+	return nil
+}
+
+// runConcurrent runs every fn at once, cancelling the context handed to the others as soon as one
+// fails. It waits for all of them to return and returns the first error.
+func runConcurrent(ctx context.Context, fns ...func(context.Context) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg    sync.WaitGroup
+		once  sync.Once
+		first error
+	)
+	for _, fn := range fns {
+		wg.Add(1)
+		go func(fn func(context.Context) error) {
+			defer wg.Done()
+			if err := fn(ctx); err != nil {
+				once.Do(func() {
+					first = err
+					cancel()
+				})
+			}
+		}(fn)
+	}
+	wg.Wait()
+	return first
+}
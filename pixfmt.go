@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// PixelFormat is the raw yuv layout frames are decoded to and VMAF reads them in
+type PixelFormat struct {
+	// Name is the ffmpeg and vmafossexec name of the format
+	Name string
+	// Chroma is libvmaf's name for the chroma subsampling
+	Chroma string
+	// BitDepth is the bits per sample, stored in two bytes above 8
+	BitDepth int
+}
+
+// DefaultPixelFormat is 8-bit 4:2:0, which every estimator supports
+var DefaultPixelFormat = PixelFormat{Name: "yuv420p", Chroma: "420", BitDepth: 8}
+
+// pixelFormats are the formats vmafossexec and libvmaf both read
+var pixelFormats = []PixelFormat{
+	DefaultPixelFormat,
+	{Name: "yuv422p", Chroma: "422", BitDepth: 8},
+	{Name: "yuv444p", Chroma: "444", BitDepth: 8},
+	{Name: "yuv420p10le", Chroma: "420", BitDepth: 10},
+	{Name: "yuv422p10le", Chroma: "422", BitDepth: 10},
+	{Name: "yuv444p10le", Chroma: "444", BitDepth: 10},
+}
+
+// ParsePixelFormat validates a --pix-fmt value
+func ParsePixelFormat(in string) (PixelFormat, error) {
+	var names []string
+	for _, format := range pixelFormats {
+		if format.Name == in {
+			return format, nil
+		}
+		names = append(names, format.Name)
+	}
+	return PixelFormat{}, fmt.Errorf("Unknown pixel format %q, must be one of %s", in, strings.Join(names, ", "))
+}
+
+// FrameSize is the size in bytes of one raw widthxheight frame
+func (p PixelFormat) FrameSize(width, height uint64) uint64 {
+	chromaWidth, chromaHeight := width, height
+	switch p.Chroma {
+	case "420":
+		chromaWidth, chromaHeight = (width+1)/2, (height+1)/2
+	case "422":
+		chromaWidth = (width + 1) / 2
+	}
+	bytesPerSample := uint64(1)
+	if p.BitDepth > 8 {
+		bytesPerSample = 2
+	}
+	return (width*height + 2*chromaWidth*chromaHeight) * bytesPerSample
+}
+
+var sourceBitDepthPattern = regexp.MustCompile(`p(\d+)(le|be)$`)
+
+// sourceBitDepth is the bit depth of an ffprobe pix_fmt such as yuv420p10le, 8 when it doesn't say
+func sourceBitDepth(pixFmt string) int {
+	match := sourceBitDepthPattern.FindStringSubmatch(pixFmt)
+	if match == nil {
+		return 8
+	}
+	depth, err := strconv.Atoi(match[1])
+	if err != nil {
+		return 8
+	}
+	return depth
+}
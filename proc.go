@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// ProcessLimits deprioritize the ffmpeg, ffprobe and vmafossexec child processes.
+// SysProcAttr has no niceness or affinity settings, and setting them on the child once it
+// has started misses the threads it already spawned, so the child is run through nice and
+// taskset, which apply them before exec'ing it.
+type ProcessLimits struct {
+	Nice        int
+	CPUAffinity []int
+}
+
+// parseCPUList parses a CPU list such as "0-3,6" into the individual CPU numbers
+func parseCPUList(in string) ([]int, error) {
+	var cpus []int
+	if in == "" {
+		return cpus, nil
+	}
+	for _, part := range strings.Split(in, ",") {
+		bounds := strings.SplitN(part, "-", 2)
+		first, err := strconv.Atoi(bounds[0])
+		if err != nil || first < 0 {
+			return nil, fmt.Errorf("invalid CPU %q in CPU list %q", bounds[0], in)
+		}
+		last := first
+		if len(bounds) == 2 {
+			if last, err = strconv.Atoi(bounds[1]); err != nil || last < first {
+				return nil, fmt.Errorf("invalid CPU range %q in CPU list %q", part, in)
+			}
+		}
+		for cpu := first; cpu <= last; cpu++ {
+			cpus = append(cpus, cpu)
+		}
+	}
+	return cpus, nil
+}
+
+// formatCPUList is the CPU list taskset takes for the CPUs
+func formatCPUList(cpus []int) string {
+	list := make([]string, len(cpus))
+	for i, cpu := range cpus {
+		list[i] = strconv.Itoa(cpu)
+	}
+	return strings.Join(list, ",")
+}
+
+// runCommand behaves like cmd.Output, running the command under the process limits
+func runCommand(cmd *exec.Cmd, limits *ProcessLimits) ([]byte, error) {
+	if limits == nil || (limits.Nice == 0 && len(limits.CPUAffinity) == 0) || cmd.Err != nil {
+		return cmd.Output()
+	}
+
+	prefix, err := limitsPrefix(limits)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to apply process limits: %v", err)
+	}
+	path, err := exec.LookPath(prefix[0])
+	if err != nil {
+		return nil, fmt.Errorf("Failed to apply process limits: %v", err)
+	}
+	cmd.Args = append(append(prefix, cmd.Path), cmd.Args[1:]...)
+	cmd.Path = path
+	return cmd.Output()
+}
+
+// nicePrefix is the nice command running a command at the limits' niceness, if any
+func nicePrefix(limits *ProcessLimits) []string {
+	if limits.Nice == 0 {
+		return nil
+	}
+	return []string{"nice", "-n", strconv.Itoa(limits.Nice)}
+}
@@ -0,0 +1,13 @@
+//go:build linux
+// +build linux
+
+package main
+
+// limitsPrefix is the command prefix applying the limits, taskset pinning the CPUs
+func limitsPrefix(limits *ProcessLimits) ([]string, error) {
+	prefix := nicePrefix(limits)
+	if len(limits.CPUAffinity) > 0 {
+		prefix = append(prefix, "taskset", "-c", formatCPUList(limits.CPUAffinity))
+	}
+	return prefix, nil
+}
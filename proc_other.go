@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "fmt"
+
+// limitsPrefix is the command prefix applying the limits, which can only be a niceness
+func limitsPrefix(limits *ProcessLimits) ([]string, error) {
+	if len(limits.CPUAffinity) > 0 {
+		return nil, fmt.Errorf("CPU affinity is only supported on linux")
+	}
+	return nicePrefix(limits), nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestRunCommandExecsThroughTheLimits(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("CPU affinity is only supported on linux")
+	}
+	// nice and taskset that note themselves down and exec the rest of their arguments
+	log := filepath.Join(t.TempDir(), "log")
+	t.Setenv("LIMITS_LOG", log)
+	wrapper := `echo "$(basename "$0") $1 $2" >> "$LIMITS_LOG"
+shift 2
+exec "$@"
+`
+	for _, name := range []string{"nice", "taskset"} {
+		bin := fakeBinary(t, name, wrapper)
+		t.Setenv("PATH", filepath.Dir(bin)+string(os.PathListSeparator)+os.Getenv("PATH"))
+	}
+	ffmpeg := fakeBinary(t, "ffmpeg", `echo "ffmpeg $*"`)
+
+	out, err := runCommand(exec.Command(ffmpeg, "-i", "in.mp4"), &ProcessLimits{Nice: 10, CPUAffinity: []int{0, 2, 3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(string(out)); got != "ffmpeg -i in.mp4" {
+		t.Errorf("output %q, want ffmpeg run with its own arguments", got)
+	}
+	wrapped, err := ioutil.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(wrapped); got != "nice -n 10\ntaskset -c 0,2,3\n" {
+		t.Errorf("ran through %q, want nice then taskset", got)
+	}
+}
+
+func TestRunCommandWithoutLimits(t *testing.T) {
+	ffmpeg := fakeBinary(t, "ffmpeg", `echo "$0"`)
+	cmd := exec.Command(ffmpeg)
+	out, err := runCommand(cmd, &ProcessLimits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(string(out)); got != ffmpeg || cmd.Path != ffmpeg {
+		t.Errorf("ran %q, want %s run directly", got, ffmpeg)
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Stages of a run timed by the ProgressReporter
+const (
+	StageProbe  = "probe"
+	StageDump   = "dump"
+	StageDecode = "decode"
+	StageVMAF   = "vmaf"
+)
+
+var stageOrder = []string{StageProbe, StageDump, StageDecode, StageVMAF}
+
+// Progress is a snapshot of how far an analysis run has got
+type Progress struct {
+	Asset string `json:"asset"`
+	// Variant, Width and Height are the pair being scored, Variant is -1 once Done
+	Variant   int    `json:"variant"`
+	Width     uint64 `json:"width,omitempty"`
+	Height    uint64 `json:"height,omitempty"`
+	Completed int    `json:"completed"`
+	Total     int    `json:"total"`
+	Done      bool   `json:"done"`
+	// ElapsedSeconds is the time since the run started, ETASeconds extrapolates the pairs left
+	// from the pace of those scored so far
+	ElapsedSeconds float64 `json:"elapsed_seconds"`
+	ETASeconds     float64 `json:"eta_seconds,omitempty"`
+}
+
+// Percent is the share of the pairs that have been scored
+func (p Progress) Percent() float64 {
+	if p.Done || p.Total == 0 {
+		return 100
+	}
+	return 100 * float64(p.Completed) / float64(p.Total)
+}
+
+// ProgressReporter counts the scored variant and resolution pairs of a run and accumulates how long
+// each stage takes, printing progress after each pair and handing snapshots to OnProgress. It's
+// safe to use from pairs scored concurrently.
+type ProgressReporter struct {
+	OnProgress func(Progress)
+
+	mu           sync.Mutex
+	progress     Progress
+	start        time.Time
+	scoringStart time.Time
+	stages       map[string]time.Duration
+}
+
+func NewProgressReporter(asset string, onProgress func(Progress)) *ProgressReporter {
+	return &ProgressReporter{
+		OnProgress: onProgress,
+		progress:   Progress{Asset: asset},
+		start:      time.Now(),
+		stages:     map[string]time.Duration{},
+	}
+}
+
+// SetTotal sets how many pairs the run will score
+func (r *ProgressReporter) SetTotal(total int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.progress.Total = total
+}
+
+// Time adds the time since start to a stage. Decodes and VMAF run concurrently through the
+// FIFOs, so stage times overlap.
+func (r *ProgressReporter) Time(stage string, start time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stages[stage] += time.Since(start)
+}
+
+// Scoring reports the pair about to be scored
+func (r *ProgressReporter) Scoring(variant int, width, height uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.scoringStart.IsZero() {
+		r.scoringStart = time.Now()
+	}
+	r.progress.Variant, r.progress.Width, r.progress.Height = variant, width, height
+	r.report()
+}
+
+// Scored counts a finished pair and prints how far the run has got
+func (r *ProgressReporter) Scored() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.progress.Completed++
+	slog.Info("Progress", "completed", r.progress.Completed, "total", r.progress.Total, "percent", roundScore(r.progress.Percent(), 1), "elapsed", time.Since(r.start).Round(time.Second), "eta", r.eta().Round(time.Second))
+}
+
+// Finish reports the run as done and prints where its time went
+func (r *ProgressReporter) Finish() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.progress.Done = true
+	r.progress.Variant, r.progress.Width, r.progress.Height = -1, 0, 0
+	r.report()
+
+	attrs := []interface{}{"elapsed", time.Since(r.start).Round(time.Second)}
+	for _, stage := range stageOrder {
+		attrs = append(attrs, stage, r.stages[stage].Round(time.Millisecond))
+	}
+	slog.Info("Finished", attrs...)
+}
+
+// StageSeconds is the accumulated time of each stage
+func (r *ProgressReporter) StageSeconds() map[string]float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	seconds := make(map[string]float64, len(r.stages))
+	for stage, d := range r.stages {
+		seconds[stage] = d.Seconds()
+	}
+	return seconds
+}
+
+func (r *ProgressReporter) report() {
+	if r.OnProgress == nil {
+		return
+	}
+	r.progress.ElapsedSeconds = time.Since(r.start).Seconds()
+	r.progress.ETASeconds = r.eta().Seconds()
+	r.OnProgress(r.progress)
+}
+
+// eta extrapolates the time left from the pace of the pairs scored so far
+func (r *ProgressReporter) eta() time.Duration {
+	remaining := r.progress.Total - r.progress.Completed
+	if r.progress.Completed == 0 || remaining <= 0 || r.progress.Done {
+		return 0
+	}
+	return time.Since(r.scoringStart) / time.Duration(r.progress.Completed) * time.Duration(remaining)
+}
+
+// StatusReporter PUTs progress as JSON to a status URL, at most once per Interval apart from
+// the final update. Failures are printed and never abort the run.
+type StatusReporter struct {
+	URL      string
+	Interval time.Duration
+	Client   *http.Client
+	lastSent time.Time
+}
+
+func NewStatusReporter(url string, interval time.Duration) *StatusReporter {
+	return &StatusReporter{
+		URL:      url,
+		Interval: interval,
+		Client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Report sends the progress unless an update went out less than Interval ago
+func (r *StatusReporter) Report(p Progress) {
+	if !p.Done && time.Since(r.lastSent) < r.Interval {
+		return
+	}
+	r.lastSent = time.Now()
+
+	payload := struct {
+		Progress
+		PercentComplete float64 `json:"percent_complete"`
+	}{p, roundScore(p.Percent(), 1)}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		slog.Error("Failed to encode status update", "error", err)
+		return
+	}
+	req, err := http.NewRequest(http.MethodPut, r.URL, bytes.NewReader(body))
+	if err != nil {
+		slog.Error("Failed to build status update", "url", r.URL, "error", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := r.Client.Do(req)
+	if err != nil {
+		slog.Error("Failed to send status update", "url", r.URL, "error", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		slog.Error("Status endpoint rejected the update", "url", r.URL, "status", resp.Status)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// referenceYUVFlag collects repeated --reference-yuv WxH=path flags, the mezzanine already
+// decoded to raw yuv at a resolution
+type referenceYUVFlag map[string]string
+
+func (r referenceYUVFlag) String() string {
+	var entries []string
+	for size, path := range r {
+		entries = append(entries, size+"="+path)
+	}
+	sort.Strings(entries)
+	return strings.Join(entries, ", ")
+}
+
+func (r referenceYUVFlag) Set(entry string) error {
+	size, path, ok := strings.Cut(entry, "=")
+	if !ok {
+		size, path, ok = strings.Cut(entry, " ")
+	}
+	if !ok || strings.TrimSpace(path) == "" {
+		return fmt.Errorf("reference yuv %q must be of the form WxH=path", entry)
+	}
+	width, height, err := parseResolution(strings.TrimSpace(size))
+	if err != nil {
+		return err
+	}
+	key := resolutionKey(width, height)
+	if _, ok := r[key]; ok {
+		return fmt.Errorf("reference yuv given twice for %s", key)
+	}
+	r[key] = strings.TrimSpace(path)
+	return nil
+}
+
+// resolutionKey names a resolution as WxH
+func resolutionKey(width, height uint64) string {
+	return fmt.Sprintf("%dx%d", width, height)
+}
+
+// validateReferenceYUV checks a pre-decoded reference holds exactly frames raw frames of widthxheight
+func validateReferenceYUV(path string, width, height, frames uint64, pixelFormat PixelFormat) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("Failed to read reference yuv %q: %v", path, err)
+	}
+	frameSize := pixelFormat.FrameSize(width, height)
+	if expected := frameSize * frames; uint64(info.Size()) != expected {
+		return fmt.Errorf("Reference yuv %q is %d bytes, but %d %dx%d %s frames of the mezzanine are %d bytes", path, info.Size(), frames, width, height, pixelFormat.Name, expected)
+	}
+	return nil
+}
+
+// streamYUV writes frames [start, end) of the raw yuv file at path into the FIFO at fifo, an end of 0
+// running to the last frame
+func streamYUV(ctx context.Context, path, fifo string, frameSize, start, end uint64, pipeSize int) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("Failed to open %q: %v", path, err)
+	}
+	defer in.Close()
+	var src io.Reader = in
+	if start > 0 {
+		if _, err := in.Seek(int64(start*frameSize), io.SeekStart); err != nil {
+			return fmt.Errorf("Failed to seek to frame %d of %q: %v", start, path, err)
+		}
+	}
+	if end > 0 {
+		src = io.LimitReader(in, int64((end-start)*frameSize))
+	}
+
+	out, err := openFifoWriter(ctx, fifo, pipeSize)
+	if err != nil {
+		return fmt.Errorf("Error opening decode output %q: %v", fifo, err)
+	}
+	defer out.Close()
+	if _, err := io.Copy(out, src); err != nil {
+		return fmt.Errorf("Failed to stream %q: %v", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+
+	"github.com/grafov/m3u8"
+)
+
+// SegmentBound maps an HLS media segment onto the [StartFrame, EndFrame) range it covers
+type SegmentBound struct {
+	Index      int    `json:"index"`
+	URI        string `json:"uri"`
+	StartFrame int    `json:"start_frame"`
+	EndFrame   int    `json:"end_frame"`
+}
+
+// SegmentScore is the pooled VMAF of the frames within one media segment
+type SegmentScore struct {
+	Variant int    `json:"variant"`
+	Width   uint64 `json:"width"`
+	Height  uint64 `json:"height"`
+	SegmentBound
+	VMAF float64 `json:"vmaf"`
+}
+
+// segmentBounds converts the #EXTINF durations of a media playlist into frame ranges
+func segmentBounds(playlist *m3u8.MediaPlaylist, fps float64) []SegmentBound {
+	var bounds []SegmentBound
+	elapsed := 0.0
+	for i, segment := range playlist.Segments {
+		// the segment slice is over-allocated, with nil entries after the last segment
+		if segment == nil {
+			break
+		}
+		start := int(math.Round(elapsed * fps))
+		elapsed += segment.Duration
+		bounds = append(bounds, SegmentBound{
+			Index:      i,
+			URI:        segment.URI,
+			StartFrame: start,
+			EndFrame:   int(math.Round(elapsed * fps)),
+		})
+	}
+	return bounds
+}
+
+// segmentScores buckets per-frame VMAF by segment and pools each segment like the pair's score;
+// segments without any scored frames are left out
+func segmentScores(frames []*VMAFFrame, bounds []SegmentBound, method PoolMethod, policy ZeroPolicy) ([]SegmentScore, error) {
+	var scores []SegmentScore
+	for _, bound := range bounds {
+		var segmentFrames []*VMAFFrame
+		for _, frame := range frames {
+			if frame.FrameNum >= bound.StartFrame && frame.FrameNum < bound.EndFrame {
+				segmentFrames = append(segmentFrames, frame)
+			}
+		}
+		if len(segmentFrames) == 0 {
+			continue
+		}
+		vmaf, err := poolFrames(segmentFrames, method, policy)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to pool segment %d: %v", bound.Index, err)
+		}
+		scores = append(scores, SegmentScore{SegmentBound: bound, VMAF: vmaf})
+	}
+	return scores, nil
+}
+
+func writeSegmentScores(path string, scores []SegmentScore) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	w.Write([]string{"variant", "resolution", "segment", "uri", "start_frame", "end_frame", "vmaf"})
+	for _, score := range scores {
+		w.Write([]string{
+			fmt.Sprintf("%d", score.Variant),
+			fmt.Sprintf("%dx%d", score.Width, score.Height),
+			fmt.Sprintf("%d", score.Index),
+			score.URI,
+			fmt.Sprintf("%d", score.StartFrame),
+			fmt.Sprintf("%d", score.EndFrame),
+			strconv.FormatFloat(score.VMAF, 'f', -1, 64),
+		})
+	}
+	w.Flush()
+	return w.Error()
+}
@@ -0,0 +1,22 @@
+package main
+
+import "testing"
+
+func TestSegmentScoresPoolLikeThePair(t *testing.T) {
+	var frames []*VMAFFrame
+	for i, score := range []float64{0, 90, 60, 90} {
+		frames = append(frames, &VMAFFrame{FrameNum: i, Metrics: &VMAFMetrics{VMAF: score, HasVMAF: true}})
+	}
+	bounds := []SegmentBound{{Index: 0, StartFrame: 0, EndFrame: 2}, {Index: 1, StartFrame: 2, EndFrame: 4}}
+
+	scores, err := segmentScores(frames, bounds, PoolMean, ZeroPolicyDrop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scores) != 2 || scores[0].VMAF != 90 || scores[1].VMAF != 75 {
+		t.Errorf("segment scores %+v, want the zero dropped and the rest mean pooled", scores)
+	}
+	if _, err := segmentScores(frames, bounds, PoolHarmonicMean, ZeroPolicyFail); err == nil {
+		t.Errorf("segment with a zero score pooled with the fail zero policy")
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// threadBudget is a weighted semaphore over the machine's threads, so concurrent VMAF runs
+// don't oversubscribe it. A nil budget is unlimited.
+type threadBudget struct {
+	size int
+
+	mu   sync.Mutex
+	used int
+	// released is closed and replaced on every release, waking the acquirers waiting on it
+	released chan struct{}
+}
+
+// newThreadBudget allows up to size threads in use at once, 0 for no limit
+func newThreadBudget(size int) *threadBudget {
+	if size <= 0 {
+		return nil
+	}
+	return &threadBudget{size: size, released: make(chan struct{})}
+}
+
+// Acquire blocks until n threads are free or ctx is done. Weights larger than the budget are
+// clamped to it, so a lone run can always proceed.
+func (b *threadBudget) Acquire(ctx context.Context, n int) error {
+	if b == nil {
+		return nil
+	}
+	if n > b.size {
+		n = b.size
+	}
+	for {
+		b.mu.Lock()
+		if b.used+n <= b.size {
+			b.used += n
+			b.mu.Unlock()
+			return nil
+		}
+		released := b.released
+		b.mu.Unlock()
+
+		select {
+		case <-released:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Release hands back n threads taken by Acquire
+func (b *threadBudget) Release(n int) {
+	if b == nil {
+		return
+	}
+	if n > b.size {
+		n = b.size
+	}
+	b.mu.Lock()
+	b.used -= n
+	close(b.released)
+	b.released = make(chan struct{})
+	b.mu.Unlock()
+}
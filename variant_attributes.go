@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/grafov/m3u8"
+)
+
+// frameRateTolerance is how far in fps a declared FRAME-RATE may be from the probed frame rate, and
+// two probed streams' frame rates from each other. 29.97 and 30 fps are different.
+const frameRateTolerance = 0.01
+
+// codecPrefixes maps the sample entry of a CODECS value onto ffprobe's codec_name
+var codecPrefixes = map[string]string{
+	"avc1": "h264",
+	"avc3": "h264",
+	"hvc1": "hevc",
+	"hev1": "hevc",
+	"av01": "av1",
+	"vp09": "vp9",
+}
+
+// VariantAttributes are the attributes a manifest declares for a variant next to what probing
+// the dumped variant found, with any disagreement between the two listed in Mismatches
+type VariantAttributes struct {
+	Codecs           string  `json:"codecs,omitempty"`
+	Resolution       string  `json:"resolution,omitempty"`
+	FrameRate        float64 `json:"frame_rate,omitempty"`
+	VideoRange       string  `json:"video_range,omitempty"`
+	HDCPLevel        string  `json:"hdcp_level,omitempty"`
+	Name             string  `json:"name,omitempty"`
+	AverageBandwidth uint32  `json:"average_bandwidth,omitempty"`
+
+	ProbedCodec      string  `json:"probed_codec"`
+	ProbedResolution string  `json:"probed_resolution"`
+	ProbedFrameRate  float64 `json:"probed_frame_rate"`
+	ProbedTransfer   string  `json:"probed_transfer,omitempty"`
+
+	Mismatches []string `json:"mismatches,omitempty"`
+}
+
+// probedVideoRange classifies a color transfer the way VIDEO-RANGE does
+func probedVideoRange(transfer string) string {
+	switch transfer {
+	case "smpte2084":
+		return "PQ"
+	case "arib-std-b67":
+		return "HLG"
+	}
+	return "SDR"
+}
+
+// variantAttributes cross-checks a variant's declared attributes against its probed video stream
+func variantAttributes(variant *m3u8.Variant, stream *FFProbeStream) *VariantAttributes {
+	attrs := &VariantAttributes{
+		Codecs:           variant.Codecs,
+		Resolution:       variant.Resolution,
+		FrameRate:        variant.FrameRate,
+		VideoRange:       variant.VideoRange,
+		HDCPLevel:        variant.HDCPLevel,
+		Name:             variant.Name,
+		AverageBandwidth: variant.AverageBandwidth,
+		ProbedCodec:      stream.CodecName,
+		ProbedResolution: fmt.Sprintf("%dx%d", stream.Width, stream.Height),
+		ProbedFrameRate:  stream.FrameRate(),
+		ProbedTransfer:   stream.ColorTransfer,
+	}
+
+	if attrs.Resolution != "" && attrs.Resolution != attrs.ProbedResolution {
+		attrs.Mismatches = append(attrs.Mismatches, fmt.Sprintf("RESOLUTION is %s but the video is %s", attrs.Resolution, attrs.ProbedResolution))
+	}
+	if attrs.FrameRate > 0 && attrs.ProbedFrameRate > 0 && math.Abs(attrs.FrameRate-attrs.ProbedFrameRate) > frameRateTolerance {
+		attrs.Mismatches = append(attrs.Mismatches, fmt.Sprintf("FRAME-RATE is %.3f but the video is %.3f fps", attrs.FrameRate, attrs.ProbedFrameRate))
+	}
+	if attrs.VideoRange != "" && attrs.VideoRange != probedVideoRange(attrs.ProbedTransfer) {
+		attrs.Mismatches = append(attrs.Mismatches, fmt.Sprintf("VIDEO-RANGE is %s but the video's %q transfer is %s", attrs.VideoRange, attrs.ProbedTransfer, probedVideoRange(attrs.ProbedTransfer)))
+	}
+
+	// only the video codec of CODECS can be checked, the audio one isn't in the dump
+	declared := ""
+	for _, codec := range strings.Split(attrs.Codecs, ",") {
+		prefix := strings.SplitN(strings.TrimSpace(codec), ".", 2)[0]
+		if name, ok := codecPrefixes[prefix]; ok {
+			declared = name
+			break
+		}
+	}
+	if declared != "" && attrs.ProbedCodec != "" && declared != attrs.ProbedCodec {
+		attrs.Mismatches = append(attrs.Mismatches, fmt.Sprintf("CODECS declares %s but the video is %s", declared, attrs.ProbedCodec))
+	}
+	return attrs
+}
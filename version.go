@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"strings"
+)
+
+// version is the analyzer's build, set with go build -ldflags "-X main.version=v1.2.3"
+var version = "dev"
+
+// ToolVersions are the builds of the analyzer and the binaries it ran, so a report can be reproduced
+type ToolVersions struct {
+	Analyzer    string `json:"analyzer"`
+	FFmpeg      string `json:"ffmpeg,omitempty"`
+	FFprobe     string `json:"ffprobe,omitempty"`
+	VMAFOSSExec string `json:"vmafossexec,omitempty"`
+	// LibVMAF is libvmaf's vmaf tool, run by the libvmaf estimator. The vmaf-cuda estimator runs
+	// ffmpeg's libvmaf_cuda filter, so is covered by FFmpeg.
+	LibVMAF string `json:"libvmaf,omitempty"`
+}
+
+// binaryVersion is the first line a binary prints for its version flag, such as
+// "ffmpeg version 6.1.1". Older vmafossexec builds without --version print their usage and exit with
+// an error, which is still reported as the first line of it.
+func binaryVersion(ctx context.Context, path string, args ...string) (string, error) {
+	out, err := runCommand(exec.CommandContext(ctx, path, args...), nil)
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		out = append(out, exitErr.Stderr...)
+	} else if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			return line, nil
+		}
+	}
+	return "", fmt.Errorf("%s printed no version", path)
+}
+
+// detectToolVersions asks ffmpeg, ffprobe and the estimator's binary for their versions, leaving
+// out those that can't be run
+func detectToolVersions(ctx context.Context, ffmpegPath, ffprobePath, estimator string) *ToolVersions {
+	versions := &ToolVersions{Analyzer: version}
+	detect := func(field *string, path string, args ...string) {
+		if path == "" {
+			return
+		}
+		v, err := binaryVersion(ctx, path, args...)
+		if err != nil {
+			slog.Warn("Unable to detect version", "binary", path, "error", err)
+			return
+		}
+		*field = v
+	}
+	detect(&versions.FFmpeg, ffmpegPath, "-version")
+	detect(&versions.FFprobe, ffprobePath, "-version")
+	switch estimator {
+	case "", EstimatorVMAF:
+		detect(&versions.VMAFOSSExec, "vmafossexec", "--version")
+	case EstimatorLibVMAF:
+		detect(&versions.LibVMAF, "vmaf", "--version")
+	}
+	return versions
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// cudaPixelFormats are the raw formats hwupload_cuda takes and libvmaf_cuda scores
+var cudaPixelFormats = []string{"yuv420p", "yuv444p"}
+
+// cudaSupports is whether frames of a raw format can be scored on the GPU
+func cudaSupports(pixelFormat string) bool {
+	for _, name := range cudaPixelFormats {
+		if name == pixelFormat {
+			return true
+		}
+	}
+	return false
+}
+
+// CUDAVMAFEstimator scores with ffmpeg's libvmaf_cuda filter, uploading the raw decodes to a CUDA
+// GPU and computing the VMAF features there. Only VMAF itself is computed.
+type CUDAVMAFEstimator struct {
+	*LibVMAFEstimator
+	// FFmpegPath is an ffmpeg built with libvmaf and CUDA support
+	FFmpegPath string
+}
+
+func NewCUDAVMAFEstimator(cpu *VMAFEstimator, ffmpegPath string) *CUDAVMAFEstimator {
+	if ffmpegPath == "" {
+		ffmpegPath = "ffmpeg"
+	}
+	return &CUDAVMAFEstimator{LibVMAFEstimator: &LibVMAFEstimator{VMAFEstimator: cpu}, FFmpegPath: ffmpegPath}
+}
+
+// Preflight checks ffmpeg has the libvmaf_cuda filter, the pixel format can be uploaded and a CUDA
+// device is visible
+func (v *CUDAVMAFEstimator) Preflight(ctx context.Context) error {
+	if !v.scoresVMAF() {
+		return fmt.Errorf("The %s estimator only computes VMAF, score %s with the %s estimator", EstimatorVMAFCUDA, v.Metric, EstimatorLibVMAF)
+	}
+	if !cudaSupports(v.PixelFormat.Name) {
+		return fmt.Errorf("The %s estimator only scores %s frames, not %s", EstimatorVMAFCUDA, strings.Join(cudaPixelFormats, " or "), v.PixelFormat.Name)
+	}
+	filters, err := runCommand(exec.CommandContext(ctx, v.FFmpegPath, "-hide_banner", "-filters"), v.Limits)
+	if err != nil {
+		return fmt.Errorf("Unable to list the filters of %s: %v", v.FFmpegPath, err)
+	}
+	if !strings.Contains(string(filters), " libvmaf_cuda ") {
+		return fmt.Errorf("%s has no libvmaf_cuda filter, the %s estimator needs ffmpeg built with --enable-libvmaf and CUDA", v.FFmpegPath, EstimatorVMAFCUDA)
+	}
+	if filepath.Ext(v.ModelPath) == ".json" {
+		if err := validateModelFile(v.ModelPath); err != nil {
+			return err
+		}
+	}
+	out, err := runCommand(exec.CommandContext(ctx, "nvidia-smi", "-L"), v.Limits)
+	if err != nil {
+		return fmt.Errorf("Unable to list CUDA devices with nvidia-smi: %v", err)
+	}
+	if !strings.Contains(string(out), "GPU ") {
+		return fmt.Errorf("No CUDA devices found for the %s estimator", EstimatorVMAFCUDA)
+	}
+	slog.Info("Using CUDA devices", "devices", strings.TrimSpace(string(out)))
+	return nil
+}
+
+// filterArgs are the libvmaf_cuda options, colons inside an option escaped from the filter's own
+func (v *CUDAVMAFEstimator) filterArgs(logsFile string) string {
+	escape := func(value string) string {
+		return strings.Replace(value, ":", `\\:`, -1)
+	}
+	args := []string{
+		"model=" + escape(v.modelArg()),
+		"log_fmt=json",
+		"log_path=" + escape(logsFile),
+		fmt.Sprintf("n_threads=%d", v.Threads),
+		"pool=" + string(v.PoolMethod),
+	}
+	if v.Subsample > 1 {
+		args = append(args, fmt.Sprintf("n_subsample=%d", v.Subsample))
+	}
+	return strings.Join(args, ":")
+}
+
+// CalculateVMAF ...
+func (v *CUDAVMAFEstimator) CalculateVMAF(ctx context.Context, variant, width, height uint64) (*VMAFResult, error) {
+	if width < v.Profile.MinResolution || height < v.Profile.MinResolution {
+		return nil, fmt.Errorf("%dx%d is below the %s model's minimum resolution of %d", width, height, v.Profile.Name, v.Profile.MinResolution)
+	}
+
+	logsFile := v.LogPath(variant, width, height)
+	size := fmt.Sprintf("%dx%d", width, height)
+	// the filter takes the distorted frames first
+	args := []string{
+		"-y", "-hide_banner", "-nostats",
+		"-f", "rawvideo", "-pix_fmt", v.PixelFormat.Name, "-s", size, "-i", v.DistortedDecodePath,
+		"-f", "rawvideo", "-pix_fmt", v.PixelFormat.Name, "-s", size, "-i", v.ReferencesDecodePath,
+		"-filter_complex", "[0:v]hwupload_cuda[dis];[1:v]hwupload_cuda[ref];[dis][ref]libvmaf_cuda=" + v.filterArgs(logsFile),
+		"-f", "null", "-",
+	}
+
+	stdoutData, err := runCommand(exec.CommandContext(ctx, v.FFmpegPath, args...), v.Limits)
+	if err != nil {
+		slog.Debug("VMAF output", "output", string(stdoutData))
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return nil, fmt.Errorf("Error running libvmaf_cuda: %s", exitErr.Stderr)
+		}
+		return nil, fmt.Errorf("Unexpected error running libvmaf_cuda: %v", err)
+	}
+	return v.poolLog(logsFile, stdoutData)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCUDAVMAFEstimatorRunsLibvmafCUDA(t *testing.T) {
+	logsDir := t.TempDir()
+	argsFile := filepath.Join(t.TempDir(), "args")
+	t.Setenv("ARGS_FILE", argsFile)
+	cpu := NewVMAFEstimator("/work/mezzanine.yuv", "/work/distorted.yuv", "vmaf_v0.6.1.pkl", logsDir, 4, 1)
+	// the fake ffmpeg writes the log libvmaf_cuda would
+	fixture := filepath.Join(logsDir, "fixture.json")
+	writeVMAFLog(t, fixture, 80, 90)
+	ffmpeg := fakeBinary(t, "ffmpeg", recordArgs+`cp `+fixture+` `+cpu.LogPath(0, 1920, 1080)+"\n")
+
+	cuda := NewCUDAVMAFEstimator(cpu, ffmpeg)
+	result, err := cuda.CalculateVMAF(context.Background(), 0, 1920, 1080)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.VMAF < 84.7 || result.VMAF > 84.8 {
+		t.Errorf("VMAF = %f, want the harmonic mean of 80 and 90", result.VMAF)
+	}
+
+	args := recordedArgs(t, argsFile)
+	inputs := []string{}
+	for i, arg := range args {
+		if arg == "-i" {
+			inputs = append(inputs, args[i+1])
+		}
+	}
+	if strings.Join(inputs, " ") != "/work/distorted.yuv /work/mezzanine.yuv" {
+		t.Errorf("inputs = %v, want the distorted decode then the reference", inputs)
+	}
+	filter := argAfter(args, "-filter_complex")
+	for _, want := range []string{"hwupload_cuda", "libvmaf_cuda=model=version=vmaf_v0.6.1:", "log_path=" + cpu.LogPath(0, 1920, 1080), "n_threads=4", "pool=harmonic_mean"} {
+		if !strings.Contains(filter, want) {
+			t.Errorf("filter %q is missing %q", filter, want)
+		}
+	}
+}
+
+func TestCUDAVMAFEstimatorPreflightRejectsUnsupportedInputs(t *testing.T) {
+	cpu := NewVMAFEstimator("ref", "dis", "vmaf_v0.6.1.pkl", t.TempDir(), 1, 1)
+	cpu.PixelFormat = PixelFormat{Name: "yuv420p10le"}
+	if err := NewCUDAVMAFEstimator(cpu, "ffmpeg").Preflight(context.Background()); err == nil {
+		t.Error("Preflight accepted yuv420p10le")
+	}
+
+	cpu = NewVMAFEstimator("ref", "dis", "vmaf_v0.6.1.pkl", t.TempDir(), 1, 1)
+	noCUDA := fakeBinary(t, "ffmpeg", "echo ' ... libvmaf          VV->V      Calculate the VMAF between two video streams.'\n")
+	err := NewCUDAVMAFEstimator(cpu, noCUDA).Preflight(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "libvmaf_cuda") {
+		t.Errorf("Preflight error = %v, want one about the missing libvmaf_cuda filter", err)
+	}
+}
@@ -2,18 +2,183 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"log/slog"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 
+	"gonum.org/v1/gonum/floats"
 	"gonum.org/v1/gonum/stat"
 )
 
 const (
 	defaultVMAFLogsDir = "logs"
+
+	// zeroClampVMAF is the score zero-VMAF frames are raised to under the clamp policy
+	zeroClampVMAF = 1.0
+)
+
+// ZeroPolicy controls how zero-VMAF frames are handled before taking the harmonic mean,
+// which would otherwise collapse to zero on a single glitched frame
+type ZeroPolicy string
+
+const (
+	ZeroPolicyNone  ZeroPolicy = "none"
+	ZeroPolicyDrop  ZeroPolicy = "drop"
+	ZeroPolicyClamp ZeroPolicy = "clamp"
+	ZeroPolicyFail  ZeroPolicy = "fail"
+)
+
+// ParseZeroPolicy validates a --hmean-zero-policy value
+func ParseZeroPolicy(in string) (ZeroPolicy, error) {
+	switch policy := ZeroPolicy(in); policy {
+	case ZeroPolicyNone, ZeroPolicyDrop, ZeroPolicyClamp, ZeroPolicyFail:
+		return policy, nil
+	}
+	return "", fmt.Errorf("unknown harmonic mean zero policy %q, must be one of none, drop, clamp or fail", in)
+}
+
+// applyZeroPolicy handles zero scores according to the policy, returning the scores
+// to pool and how many frames were zero
+func applyZeroPolicy(scores []float64, policy ZeroPolicy) ([]float64, int, error) {
+	result := make([]float64, 0, len(scores))
+	zeros := 0
+	for _, score := range scores {
+		if score > 0 {
+			result = append(result, score)
+			continue
+		}
+
+		zeros++
+		switch policy {
+		case ZeroPolicyDrop:
+		case ZeroPolicyClamp:
+			result = append(result, zeroClampVMAF)
+		case ZeroPolicyFail:
+			return nil, zeros, fmt.Errorf("frame with a VMAF score of zero found with the fail zero policy")
+		default:
+			result = append(result, score)
+		}
+	}
+	return result, zeros, nil
+}
+
+// PoolMethod is how per-frame VMAF scores are reduced to a single score
+type PoolMethod string
+
+const (
+	PoolMean         PoolMethod = "mean"
+	PoolMin          PoolMethod = "min"
+	PoolMax          PoolMethod = "max"
+	PoolHarmonicMean PoolMethod = "harmonic_mean"
 )
 
+// ParsePoolMethod validates a --pool value
+func ParsePoolMethod(in string) (PoolMethod, error) {
+	switch method := PoolMethod(in); method {
+	case PoolMean, PoolMin, PoolMax, PoolHarmonicMean:
+		return method, nil
+	}
+	return "", fmt.Errorf("unknown pooling method %q, must be one of mean, min, max or harmonic_mean", in)
+}
+
+// Metric is the score a run is averaged and gated on
+type Metric string
+
+const (
+	MetricVMAF Metric = "vmaf"
+	MetricPSNR Metric = "psnr"
+	MetricSSIM Metric = "ssim"
+)
+
+// ParseMetric validates a --metric value
+func ParseMetric(in string) (Metric, error) {
+	switch metric := Metric(in); metric {
+	case MetricVMAF, MetricPSNR, MetricSSIM:
+		return metric, nil
+	}
+	return "", fmt.Errorf("unknown metric %q, must be one of vmaf, psnr or ssim", in)
+}
+
+// libvmafFeature is the libvmaf feature extractor computing the metric
+func (m Metric) libvmafFeature() string {
+	if m == MetricSSIM {
+		return "float_ssim"
+	}
+	return string(m)
+}
+
+// vmafossexecArg is the pooling vmafossexec is asked for. It has no max pooling, and as the
+// scores are pooled again from the per-frame log its choice only affects the log's summary.
+func (m PoolMethod) vmafossexecArg() string {
+	if m == PoolMax {
+		return string(PoolMean)
+	}
+	return string(m)
+}
+
+// pool reduces the scores with the method
+func (m PoolMethod) pool(scores []float64) float64 {
+	if len(scores) == 0 {
+		return 0
+	}
+	switch m {
+	case PoolMean:
+		return stat.Mean(scores, nil)
+	case PoolMin:
+		return floats.Min(scores)
+	case PoolMax:
+		return floats.Max(scores)
+	default:
+		return stat.HarmonicMean(scores, nil)
+	}
+}
+
+// extractMetric collects one score of every frame by its log name, vmaf, psnr, ssim or ms_ssim.
+// Frames without metrics score 0, and unknown names give nil.
+func extractMetric(frames []*VMAFFrame, name string) []float64 {
+	var score func(*VMAFMetrics) float64
+	switch name {
+	case "vmaf":
+		score = func(m *VMAFMetrics) float64 { return m.VMAF }
+	case "psnr":
+		score = (*VMAFMetrics).PSNR
+	case "ssim":
+		score = (*VMAFMetrics).SSIM
+	case "ms_ssim":
+		score = (*VMAFMetrics).MSSSIM
+	default:
+		return nil
+	}
+	scores := make([]float64, len(frames))
+	for i, frame := range frames {
+		if frame.Metrics != nil {
+			scores[i] = score(frame.Metrics)
+		}
+	}
+	return scores
+}
+
+// poolFrames pools the VMAF of the frames with the method after applying the zero policy, the same
+// way a pair's score is, 0 when there are none
+func poolFrames(frames []*VMAFFrame, method PoolMethod, policy ZeroPolicy) (float64, error) {
+	scores, _, err := applyZeroPolicy(extractMetric(frames, "vmaf"), policy)
+	if err != nil {
+		return 0, err
+	}
+	return method.pool(scores), nil
+}
+
 type VMAFLog struct {
 	Version string
 	Params  *VMAFParams
@@ -33,6 +198,7 @@ type VMAFFrame struct {
 	Metrics  *VMAFMetrics
 }
 
+// VMAFMetrics are a frame's scores, vmafossexec and libvmaf name the PSNR and SSIM metrics differently
 type VMAFMetrics struct {
 	Adm2      float64 `json:"adm2"`
 	Motion2   float64 `json:"motion2"`
@@ -44,6 +210,208 @@ type VMAFMetrics struct {
 	VifScale2 float64 `json:"vif_scale2"`
 	VifScale3 float64 `json:"vif_scale3"`
 	VMAF      float64 `json:"vmaf"`
+
+	PsnrY       float64 `json:"psnr_y"`
+	FloatSsim   float64 `json:"float_ssim"`
+	FloatMsSsim float64 `json:"float_ms_ssim"`
+
+	// HasVMAF is set when the frame carried a VMAF score under any of its names
+	HasVMAF bool `json:"-"`
+}
+
+// Names vmafossexec and libvmaf builds have logged metrics under besides their field's own JSON
+// name, compared case insensitively
+var (
+	vmafAliases   = []string{"vmaf", "vmaf_score", "vmaf_v0.6.1", "vmaf_4k_v0.6.1"}
+	psnrAliases   = []string{"psnr", "psnr_score"}
+	ssimAliases   = []string{"ssim", "ssim_score"}
+	msSsimAliases = []string{"ms_ssim", "ms-ssim", "ms_ssim_score"}
+)
+
+// UnmarshalJSON reads a frame's metrics, taking each from whichever of its known names the log uses
+func (m *VMAFMetrics) UnmarshalJSON(data []byte) error {
+	type plain VMAFMetrics
+	if err := json.Unmarshal(data, (*plain)(m)); err != nil {
+		return err
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	byName := make(map[string]json.RawMessage, len(raw))
+	for key, value := range raw {
+		byName[strings.ToLower(key)] = value
+	}
+
+	// lookup fills field from the first alias present, reporting whether there was one
+	lookup := func(field *float64, aliases []string) (bool, error) {
+		for _, alias := range aliases {
+			if value, ok := byName[alias]; ok {
+				if err := json.Unmarshal(value, field); err != nil {
+					return false, fmt.Errorf("invalid %s in VMAF log: %v", alias, err)
+				}
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	var err error
+	if m.HasVMAF, err = lookup(&m.VMAF, vmafAliases); err != nil {
+		return err
+	}
+	for field, aliases := range map[*float64][]string{&m.Psnr: psnrAliases, &m.Ssim: ssimAliases, &m.MsSsim: msSsimAliases} {
+		if _, err := lookup(field, aliases); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PSNR returns the luma PSNR from either log schema
+func (m *VMAFMetrics) PSNR() float64 {
+	if m.Psnr != 0 {
+		return m.Psnr
+	}
+	return m.PsnrY
+}
+
+// SSIM returns the SSIM from either log schema
+func (m *VMAFMetrics) SSIM() float64 {
+	if m.Ssim != 0 {
+		return m.Ssim
+	}
+	return m.FloatSsim
+}
+
+// MSSSIM returns the MS-SSIM from either log schema
+func (m *VMAFMetrics) MSSSIM() float64 {
+	if m.MsSsim != 0 {
+		return m.MsSsim
+	}
+	return m.FloatMsSsim
+}
+
+// VMAFResult pools the per-frame metrics of a run, VMAF with the estimator's PoolMethod and the rest
+// with the arithmetic mean. Metrics the estimator doesn't compute are left at zero.
+type VMAFResult struct {
+	VMAF   float64
+	PSNR   float64
+	SSIM   float64
+	MSSSIM float64
+	// Transform is the score transform the model applied, TransformPhone or empty for none
+	Transform string
+	// Metric is the score Score reports, VMAF when empty
+	Metric Metric
+
+	// Spread is how consistent VMAF was across the frames pooled into VMAF
+	Spread VMAFSpread
+}
+
+// VMAFSpread describes the distribution of per-frame VMAF scores, telling consistent quality
+// from mostly high scores with a few bad dips
+type VMAFSpread struct {
+	StdDev float64
+	P5     float64
+	P95    float64
+	Min    float64
+}
+
+// frameSpread computes the spread of per-frame scores, percentiles taken from the empirical
+// distribution
+func frameSpread(scores []float64) VMAFSpread {
+	if len(scores) == 0 {
+		return VMAFSpread{}
+	}
+	sorted := append([]float64(nil), scores...)
+	sort.Float64s(sorted)
+	return VMAFSpread{
+		StdDev: stat.PopStdDev(sorted, nil),
+		P5:     stat.Quantile(0.05, stat.Empirical, sorted, nil),
+		P95:    stat.Quantile(0.95, stat.Empirical, sorted, nil),
+		Min:    sorted[0],
+	}
+}
+
+// Score is the pooled value of the metric the run is averaged and gated on
+func (r *VMAFResult) Score() float64 {
+	switch r.Metric {
+	case MetricPSNR:
+		return r.PSNR
+	case MetricSSIM:
+		return r.SSIM
+	}
+	return r.VMAF
+}
+
+// TransformPhone marks scores transformed for viewing on a phone, which aren't comparable
+// with untransformed ones
+const TransformPhone = "phone"
+
+// ModelProfile describes the viewing conditions a shipped VMAF model was trained for
+type ModelProfile struct {
+	Name string
+	// MinResolution is the smallest comparison width or height the model gives meaningful scores for
+	MinResolution uint64
+	// MinSourceWidth is the narrowest mezzanine the model can be used with at all
+	MinSourceWidth uint64
+	// RecommendedSourceWidth is the mezzanine width the model expects, narrower sources get a warning
+	RecommendedSourceWidth uint64
+}
+
+var (
+	defaultModelProfile = &ModelProfile{
+		Name:                   "1080p",
+		MinResolution:          minVmafResolution,
+		RecommendedSourceWidth: 1920,
+	}
+
+	// modelProfiles are keyed by the file name of each model shipped with vmaf
+	modelProfiles = map[string]*ModelProfile{
+		"vmaf_v0.6.1.pkl":    defaultModelProfile,
+		"vmaf_v0.6.1neg.pkl": defaultModelProfile,
+		"vmaf_4k_v0.6.1.pkl": {
+			Name:                   "4k",
+			MinResolution:          720,
+			MinSourceWidth:         1920,
+			RecommendedSourceWidth: 3840,
+		},
+	}
+)
+
+// LookupModelProfile finds the profile of a model by its file name, falling back to the 1080p profile
+func LookupModelProfile(modelPath string) *ModelProfile {
+	if profile, ok := modelProfiles[filepath.Base(modelPath)]; ok {
+		return profile
+	}
+	return defaultModelProfile
+}
+
+// Estimator scores the decoded reference and distorted FIFOs against each other
+type Estimator interface {
+	ValidateSource(width, height uint64) error
+	CalculateVMAF(ctx context.Context, variant, width, height uint64) (*VMAFResult, error)
+	ReadLog(variant, width, height uint64) (*VMAFLog, error)
+}
+
+const (
+	EstimatorVMAF     = "vmaf"
+	EstimatorLibVMAF  = "libvmaf"
+	EstimatorVMAFCUDA = "vmaf-cuda"
+)
+
+// modelSHA256 hashes a model file so runs can be tied to the exact model that scored them
+func modelSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
 }
 
 type VMAFEstimator struct {
@@ -52,25 +420,221 @@ type VMAFEstimator struct {
 	ModelPath            string
 	LogsDir              string
 	Threads              uint64
+	Profile              *ModelProfile
+	ZeroPolicy           ZeroPolicy
+	PoolMethod           PoolMethod
+	Limits               *ProcessLimits
+	// PhoneModel applies the model's phone transform, scoring for small screens
+	PhoneModel bool
+	// Subsample scores every Nth frame, 0 or 1 scores them all
+	Subsample uint64
+	// Metric other than VMAF skips the model, which only libvmaf supports
+	Metric Metric
+	// PixelFormat is the raw format of the decoded frames
+	PixelFormat PixelFormat
+	// Extras are the metrics computed alongside VMAF
+	Extras ExtraMetrics
+}
+
+// ExtraMetrics are the metrics that can be computed alongside VMAF, each adding to its runtime
+type ExtraMetrics struct {
+	PSNR, SSIM, MSSSIM bool
+}
+
+// AllExtraMetrics computes every extra metric, as vmaf_analyzer always has
+var AllExtraMetrics = ExtraMetrics{PSNR: true, SSIM: true, MSSSIM: true}
+
+// vmafossexecArgs are the vmafossexec flags computing the metrics
+func (e ExtraMetrics) vmafossexecArgs() []string {
+	var args []string
+	if e.PSNR {
+		args = append(args, "--psnr")
+	}
+	if e.SSIM {
+		args = append(args, "--ssim")
+	}
+	if e.MSSSIM {
+		args = append(args, "--ms-ssim")
+	}
+	return args
+}
+
+// scoresVMAF is whether runs predict VMAF rather than only computing a cheaper metric
+func (v *VMAFEstimator) scoresVMAF() bool {
+	return v.Metric == "" || v.Metric == MetricVMAF
 }
 
 // NewVMAFEstimator ...
-func NewVMAFEstimator(referencePath, distortedPath, modelPath, logsDir string, threads uint64) *VMAFEstimator {
+func NewVMAFEstimator(referencePath, distortedPath, modelPath, logsDir string, threads, subsample uint64) *VMAFEstimator {
 	return &VMAFEstimator{
 		ReferencesDecodePath: referencePath,
-		DistortedDecodePath:  distortedDecodePath,
+		DistortedDecodePath:  distortedPath,
 		ModelPath:            modelPath,
 		LogsDir:              logsDir,
 		Threads:              threads,
+		Subsample:            subsample,
+		Profile:              LookupModelProfile(modelPath),
+		ZeroPolicy:           ZeroPolicyNone,
+		PoolMethod:           PoolHarmonicMean,
+		PixelFormat:          DefaultPixelFormat,
+		Extras:               AllExtraMetrics,
+	}
+}
+
+// Transform is the score transform applied to every result
+func (v *VMAFEstimator) Transform() string {
+	if v.PhoneModel {
+		return TransformPhone
+	}
+	return ""
+}
+
+// validateModelFile checks a model exists and looks like one before anything is decoded. vmafossexec
+// loads a .pkl model together with its companion .pkl.model file, libvmaf loads .json models.
+func validateModelFile(path string) error {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("Failed to read VMAF model %q: %v", path, err)
+	}
+	switch filepath.Ext(path) {
+	case ".pkl":
+		// pickles start with a protocol 0 mark or the opcode of a binary protocol
+		if len(raw) == 0 || (raw[0] != '(' && raw[0] != 0x80) {
+			return fmt.Errorf("VMAF model %q isn't a pickled model", path)
+		}
+		if _, err := os.Stat(path + ".model"); err != nil {
+			return fmt.Errorf("VMAF model %q is missing its companion %q: %v", path, path+".model", err)
+		}
+	case ".json":
+		if !json.Valid(raw) {
+			return fmt.Errorf("VMAF model %q isn't valid JSON", path)
+		}
+	default:
+		return fmt.Errorf("VMAF model %q must be a .pkl or .json model", path)
+	}
+	return nil
+}
+
+// Preflight checks vmafossexec loads the model, scoring a single black frame against itself with it
+// so a bad model fails the run before anything is decoded
+func (v *VMAFEstimator) Preflight(ctx context.Context) error {
+	if err := validateModelFile(v.ModelPath); err != nil {
+		return err
+	}
+	dir, err := ioutil.TempDir("", "vmaf-preflight")
+	if err != nil {
+		return fmt.Errorf("Failed to create VMAF preflight directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	size := v.Profile.MinResolution
+	if size < 64 {
+		size = 64
+	}
+	frame := filepath.Join(dir, "frame.yuv")
+	if err := ioutil.WriteFile(frame, make([]byte, v.PixelFormat.FrameSize(size, size)), 0644); err != nil {
+		return fmt.Errorf("Failed to write VMAF preflight frame: %v", err)
+	}
+	preflightCmd := exec.CommandContext(ctx,
+		"vmafossexec",
+		v.PixelFormat.Name,
+		fmt.Sprintf("%d", size),
+		fmt.Sprintf("%d", size),
+		frame,
+		frame,
+		v.ModelPath,
+		"--log", filepath.Join(dir, "preflight.log"),
+		"--log-fmt", "json",
+		"--thread", "1")
+	if v.PhoneModel {
+		preflightCmd.Args = append(preflightCmd.Args, "--phone-model")
 	}
+	if stdoutData, err := runCommand(preflightCmd, v.Limits); err != nil {
+		slog.Debug("VMAF preflight output", "output", string(stdoutData))
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return fmt.Errorf("VMAF failed to load model %q: %s", v.ModelPath, exitErr.Stderr)
+		}
+		return fmt.Errorf("Unexpected error running vmaf: %v", err)
+	}
+	return nil
+}
+
+// LogPath returns where the JSON log for a variant at the given resolution is written
+func (v *VMAFEstimator) LogPath(variant, width, height uint64) string {
+	return fmt.Sprintf("%s/%d_%d_%d.log", v.LogsDir, variant, width, height)
+}
+
+// FramesCSVPath returns where the per-frame CSV for a variant at the given resolution is written,
+// next to its JSON log
+func (v *VMAFEstimator) FramesCSVPath(variant, width, height uint64) string {
+	return strings.TrimSuffix(v.LogPath(variant, width, height), ".log") + ".csv"
+}
+
+// writeFramesCSV writes the per-frame scores of a log, for tracking down where quality dips. The
+// columns of metrics that weren't computed are left empty.
+func writeFramesCSV(path string, frames []*VMAFFrame, extras ExtraMetrics) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	format := func(score float64, computed bool) string {
+		if !computed {
+			return ""
+		}
+		return strconv.FormatFloat(score, 'f', -1, 64)
+	}
+	w := csv.NewWriter(f)
+	w.Write([]string{"frame", "vmaf", "psnr", "ssim", "ms_ssim"})
+	for _, frame := range frames {
+		w.Write([]string{
+			fmt.Sprintf("%d", frame.FrameNum),
+			format(frame.Metrics.VMAF, true),
+			format(frame.Metrics.PSNR(), extras.PSNR),
+			format(frame.Metrics.SSIM(), extras.SSIM),
+			format(frame.Metrics.MSSSIM(), extras.MSSSIM),
+		})
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// ReadLog parses the JSON log written by a previous CalculateVMAF call
+func (v *VMAFEstimator) ReadLog(variant, width, height uint64) (*VMAFLog, error) {
+	vmafRawOutput, err := ioutil.ReadFile(v.LogPath(variant, width, height))
+	if err != nil {
+		return nil, err
+	}
+
+	var vmafResult VMAFLog
+	if err := json.Unmarshal(vmafRawOutput, &vmafResult); err != nil {
+		return nil, err
+	}
+	return &vmafResult, nil
+}
+
+// ValidateSource checks the mezzanine is large enough for the model, warning when it's smaller than recommended
+func (v *VMAFEstimator) ValidateSource(width, height uint64) error {
+	if width < v.Profile.MinSourceWidth {
+		return fmt.Errorf("%dx%d source is too small for the %s model %q, which needs a source at least %d wide", width, height, v.Profile.Name, v.ModelPath, v.Profile.MinSourceWidth)
+	}
+	if width < v.Profile.RecommendedSourceWidth {
+		slog.Warn("Source is smaller than the model expects", resolution(width, height), "expected_width", v.Profile.RecommendedSourceWidth, "profile", v.Profile.Name, "model", v.ModelPath)
+	}
+	return nil
 }
 
 // CalculateVMAF ...
-func (v *VMAFEstimator) CalculateVMAF(ctx context.Context, variant, width, height uint64) (float64, error) {
-	logsFile := fmt.Sprintf("%s/%d_%d_%d.log", v.LogsDir, variant, width, height)
+func (v *VMAFEstimator) CalculateVMAF(ctx context.Context, variant, width, height uint64) (*VMAFResult, error) {
+	if width < v.Profile.MinResolution || height < v.Profile.MinResolution {
+		return nil, fmt.Errorf("%dx%d is below the %s model's minimum resolution of %d", width, height, v.Profile.Name, v.Profile.MinResolution)
+	}
+
+	logsFile := v.LogPath(variant, width, height)
 	vmafCmd := exec.CommandContext(ctx,
 		"vmafossexec",
-		"yuv420p",
+		v.PixelFormat.Name,
 		fmt.Sprintf("%d", width),
 		fmt.Sprintf("%d", height),
 		v.ReferencesDecodePath,
@@ -79,37 +643,80 @@ func (v *VMAFEstimator) CalculateVMAF(ctx context.Context, variant, width, heigh
 		"--log", logsFile,
 		"--log-fmt", "json",
 		"--thread", fmt.Sprintf("%d", v.Threads),
-		"--pool", "harmonic_mean",
-		"--psnr",
-		"--ssim",
-		"--ms-ssim")
+		"--pool", v.PoolMethod.vmafossexecArg())
+	vmafCmd.Args = append(vmafCmd.Args, v.Extras.vmafossexecArgs()...)
+	if v.PhoneModel {
+		vmafCmd.Args = append(vmafCmd.Args, "--phone-model")
+	}
+	if v.Subsample > 1 {
+		vmafCmd.Args = append(vmafCmd.Args, "--subsample", fmt.Sprintf("%d", v.Subsample))
+	}
 
-	stdoutData, err := vmafCmd.Output()
+	stdoutData, err := runCommand(vmafCmd, v.Limits)
 	if err != nil {
-		fmt.Printf("VMAF output:\n%s\n", string(stdoutData))
+		slog.Debug("VMAF output", "output", string(stdoutData))
 		if exitErr, ok := err.(*exec.ExitError); ok {
-			return 0, fmt.Errorf("Error running VMAF: %s", exitErr.Stderr)
+			return nil, fmt.Errorf("Error running VMAF: %s", exitErr.Stderr)
 		}
-		return 0, fmt.Errorf("Unexpected error running vmaf: %v", err)
+		return nil, fmt.Errorf("Unexpected error running vmaf: %v", err)
 	}
 
+	return v.poolLog(logsFile, stdoutData)
+}
+
+// poolLog reads the per-frame scores from a JSON log and pools them with the PoolMethod
+func (v *VMAFEstimator) poolLog(logsFile string, stdoutData []byte) (*VMAFResult, error) {
 	vmafRawOutput, err := ioutil.ReadFile(logsFile)
 	if err != nil {
-		fmt.Printf("Failed to read VMAF logs output: %v\n", err)
-		return 0, err
+		slog.Error("Failed to read VMAF log", "path", logsFile, "error", err)
+		return nil, err
 	}
 
 	var vmafResult VMAFLog
 	if err := json.Unmarshal(vmafRawOutput, &vmafResult); err != nil {
-		fmt.Printf("Failed to unmarshal vmaf logs: %v\n", err)
-		fmt.Printf("This is vmaf stdout: %s\n", stdoutData)
-		fmt.Printf("This is the log: %s\n", vmafRawOutput)
-		return 0, err
+		slog.Error("Failed to unmarshal VMAF log", "path", logsFile, "error", err, "stdout", string(stdoutData), "log", string(vmafRawOutput))
+		return nil, err
+	}
+	// vmafossexec echoes its parameters, libvmaf doesn't
+	if vmafResult.Params != nil && v.Subsample > 1 && uint64(vmafResult.Params.Subsample) != v.Subsample {
+		slog.Warn("VMAF log reports a different subsample than requested", "requested", v.Subsample, "logged", vmafResult.Params.Subsample)
+	}
+
+	// pooling no frames gives NaN, which would poison the weighted average
+	if len(vmafResult.Frames) == 0 {
+		return nil, fmt.Errorf("VMAF log %q has no frames, most likely a decode produced no output", logsFile)
+	}
+	for _, frame := range vmafResult.Frames {
+		// a VMAF logged under a name we don't know would otherwise pool as zeros
+		if frame.Metrics == nil || (v.scoresVMAF() && !frame.Metrics.HasVMAF) {
+			return nil, fmt.Errorf("Frame %d of VMAF log %q has no VMAF score under any of the known names %s", frame.FrameNum, logsFile, strings.Join(vmafAliases, ", "))
+		}
 	}
 
-	vmafScores := make([]float64, len(vmafResult.Frames))
-	for i, frame := range vmafResult.Frames {
-		vmafScores[i] = frame.Metrics.VMAF
+	result := &VMAFResult{
+		PSNR:      stat.Mean(extractMetric(vmafResult.Frames, "psnr"), nil),
+		SSIM:      stat.Mean(extractMetric(vmafResult.Frames, "ssim"), nil),
+		MSSSIM:    stat.Mean(extractMetric(vmafResult.Frames, "ms_ssim"), nil),
+		Transform: v.Transform(),
+		Metric:    v.Metric,
+	}
+	// every frame scores zero without a model
+	if !v.scoresVMAF() {
+		return result, nil
+	}
+
+	vmafScores := extractMetric(vmafResult.Frames, "vmaf")
+	if floats.Max(vmafScores) == 0 {
+		return nil, fmt.Errorf("Every one of the %d frames of VMAF log %q scored 0 VMAF, most likely the decodes produced blank or misaligned frames", len(vmafScores), logsFile)
+	}
+	vmafScores, zeros, err := applyZeroPolicy(vmafScores, v.ZeroPolicy)
+	if err != nil {
+		return nil, err
+	}
+	if zeros > 0 {
+		slog.Info("Applied zero policy to frames with a VMAF score of zero", "policy", v.ZeroPolicy, "zero_frames", zeros, "frames", len(vmafResult.Frames))
 	}
-	return stat.HarmonicMean(vmafScores, nil), nil
+	result.VMAF = v.PoolMethod.pool(vmafScores)
+	result.Spread = frameSpread(vmafScores)
+	return result, nil
 }
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeVMAFOSSExec puts a vmafossexec on PATH recording its arguments and logging a frame scoring
+// vmaf, with the --subsample it was given echoed in the log's params like vmafossexec does
+func fakeVMAFOSSExec(t *testing.T, vmaf string) string {
+	t.Helper()
+	argsFile := filepath.Join(t.TempDir(), "args")
+	t.Setenv("ARGS_FILE", argsFile)
+	script := recordArgs + `log= subsample=1
+while [ $# -gt 0 ]; do
+	case "$1" in
+	--log) log=$2 ;;
+	--subsample) subsample=$2 ;;
+	esac
+	shift
+done
+printf '{"params":{"subsample":%s},"frames":[{"frameNum":0,"metrics":{"vmaf":` + vmaf + `}}]}' "$subsample" > "$log"
+`
+	bin := fakeBinary(t, "vmafossexec", script)
+	t.Setenv("PATH", filepath.Dir(bin)+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return argsFile
+}
+
+func TestVMAFEstimatorPassesSubsample(t *testing.T) {
+	argsFile := fakeVMAFOSSExec(t, "90")
+
+	v := NewVMAFEstimator("/work/mezzanine.yuv", "/work/distorted.yuv", "vmaf_v0.6.1.pkl", t.TempDir(), 2, 5)
+	if _, err := v.CalculateVMAF(context.Background(), 0, 1280, 720); err != nil {
+		t.Fatal(err)
+	}
+	if got := argAfter(recordedArgs(t, argsFile), "--subsample"); got != "5" {
+		t.Errorf("--subsample = %q, want 5", got)
+	}
+	vmafLog, err := v.ReadLog(0, 1280, 720)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vmafLog.Params == nil || vmafLog.Params.Subsample != 5 {
+		t.Errorf("log params %+v, want subsample 5", vmafLog.Params)
+	}
+}
+
+func TestVMAFEstimatorScoresEveryFrameByDefault(t *testing.T) {
+	argsFile := fakeVMAFOSSExec(t, "90")
+
+	if got := flag.Lookup("subsample").DefValue; got != "1" {
+		t.Errorf("--subsample defaults to %s, want every frame scored", got)
+	}
+	v := NewVMAFEstimator("/work/mezzanine.yuv", "/work/distorted.yuv", "vmaf_v0.6.1.pkl", t.TempDir(), 2, 1)
+	if _, err := v.CalculateVMAF(context.Background(), 0, 1280, 720); err != nil {
+		t.Fatal(err)
+	}
+	for _, arg := range recordedArgs(t, argsFile) {
+		if arg == "--subsample" {
+			t.Errorf("--subsample passed for a subsample of 1")
+		}
+	}
+}
+
+func TestVMAFEstimatorSkipsExtraMetrics(t *testing.T) {
+	tests := []struct {
+		flags map[string]string
+		// skipped are the vmafossexec flags left out
+		skipped []string
+	}{
+		{nil, nil},
+		// first, as --vmaf-only refuses the other flags once they've been set
+		{map[string]string{"vmaf-only": "true"}, []string{"--psnr", "--ssim", "--ms-ssim"}},
+		{map[string]string{"with-psnr": "false"}, []string{"--psnr"}},
+		{map[string]string{"with-ssim": "false"}, []string{"--ssim"}},
+		{map[string]string{"with-ms-ssim": "false"}, []string{"--ms-ssim"}},
+	}
+	for _, test := range tests {
+		for name, value := range test.flags {
+			if err := flag.Set(name, value); err != nil {
+				t.Fatal(err)
+			}
+		}
+		extras, err := extraMetricsFromFlags()
+		for name := range test.flags {
+			flag.Set(name, flag.Lookup(name).DefValue)
+		}
+		if err != nil {
+			t.Fatalf("flags %v: %v", test.flags, err)
+		}
+		argsFile := fakeVMAFOSSExec(t, "90")
+		v := NewVMAFEstimator("/work/mezzanine.yuv", "/work/distorted.yuv", "vmaf_v0.6.1.pkl", t.TempDir(), 2, 1)
+		v.Extras = extras
+		if _, err := v.CalculateVMAF(context.Background(), 0, 1280, 720); err != nil {
+			t.Fatal(err)
+		}
+		passed := map[string]bool{}
+		for _, arg := range recordedArgs(t, argsFile) {
+			passed[arg] = true
+		}
+		for _, metric := range []string{"--psnr", "--ssim", "--ms-ssim"} {
+			want := true
+			for _, skipped := range test.skipped {
+				want = want && metric != skipped
+			}
+			if passed[metric] != want {
+				t.Errorf("flags %v: vmafossexec passed %s %v, want %v", test.flags, metric, passed[metric], want)
+			}
+		}
+		if got := argAfter(recordedArgs(t, argsFile), "--log"); got == "" {
+			t.Errorf("flags %v: no --log passed", test.flags)
+		}
+	}
+}
+
+func TestNewVMAFEstimatorUsesItsPaths(t *testing.T) {
+	v := NewVMAFEstimator("/custom/reference.yuv", "/custom/distorted.yuv", "vmaf_v0.6.1.pkl", t.TempDir(), 2, 1)
+	if v.DistortedDecodePath != "/custom/distorted.yuv" {
+		t.Errorf("DistortedDecodePath = %q, want the distorted path passed in", v.DistortedDecodePath)
+	}
+	if v.ReferencesDecodePath != "/custom/reference.yuv" {
+		t.Errorf("ReferencesDecodePath = %q, want the reference path passed in", v.ReferencesDecodePath)
+	}
+
+	argsFile := fakeVMAFOSSExec(t, "90")
+	if _, err := v.CalculateVMAF(context.Background(), 0, 1280, 720); err != nil {
+		t.Fatal(err)
+	}
+	// vmafossexec takes the pixel format, width, height, reference, distorted and model
+	if args := recordedArgs(t, argsFile); len(args) < 5 || args[4] != "/custom/distorted.yuv" {
+		t.Errorf("vmafossexec args %v, want the distorted path fifth", args)
+	}
+}
+
+func TestFrameSpread(t *testing.T) {
+	near := func(got, want float64) bool { return math.Abs(got-want) < 1e-3 }
+
+	// 1 to 100 out of order
+	uniform := make([]float64, 100)
+	for i := range uniform {
+		uniform[i] = float64((i*37)%100 + 1)
+	}
+	spread := frameSpread(uniform)
+	if !near(spread.P5, 5) || !near(spread.P95, 95) || !near(spread.Min, 1) || !near(spread.StdDev, 28.866) {
+		t.Errorf("spread of 1 to 100 is %+v, want p5 5, p95 95, min 1 and stddev 28.866", spread)
+	}
+
+	// consistently 85 against mostly 95 with a few 40s
+	consistent, dips := make([]float64, 100), make([]float64, 100)
+	for i := range consistent {
+		consistent[i], dips[i] = 85, 95
+		if i%20 == 0 {
+			dips[i] = 40
+		}
+	}
+	if spread := frameSpread(consistent); spread.StdDev != 0 || spread.P5 != 85 || spread.Min != 85 {
+		t.Errorf("spread of a constant 85 is %+v, want no deviation", spread)
+	}
+	if spread := frameSpread(dips); spread.P5 != 40 || spread.P95 != 95 || spread.Min != 40 || spread.StdDev < 10 {
+		t.Errorf("spread with 40 VMAF dips is %+v, want p5 and min 40", spread)
+	}
+
+	if spread := frameSpread(nil); spread != (VMAFSpread{}) {
+		t.Errorf("spread of no frames is %+v, want zero", spread)
+	}
+}
+
+// vmafossexec 1.3 logs metrics under lowercase names, 1.5 builds suffix them with _score
+const (
+	vmafossexec13Log = `{"version":"1.3.15","params":{"model":"vmaf_v0.6.1.pkl","scaledWidth":1280,"scaledHeight":720,"subsample":1},` +
+		`"frames":[{"frameNum":0,"metrics":{"adm2":0.98,"motion2":0,"ms_ssim":0.96,"psnr":38.5,"ssim":0.97,"vif_scale0":0.8,"vmaf":90}},` +
+		`{"frameNum":1,"metrics":{"adm2":0.97,"motion2":1.2,"ms_ssim":0.94,"psnr":37.5,"ssim":0.95,"vif_scale0":0.7,"vmaf":80}}]}`
+	vmafossexec15Log = `{"version":"1.5.3","params":{"model":"vmaf_v0.6.1.pkl","scaledWidth":1280,"scaledHeight":720,"subsample":1},` +
+		`"frames":[{"frameNum":0,"metrics":{"adm2":0.98,"motion2":0,"MS_SSIM_score":0.96,"PSNR_score":38.5,"SSIM_score":0.97,"vif_scale0":0.8,"VMAF_score":90}},` +
+		`{"frameNum":1,"metrics":{"adm2":0.97,"motion2":1.2,"MS_SSIM_score":0.94,"PSNR_score":37.5,"SSIM_score":0.95,"vif_scale0":0.7,"VMAF_score":80}}]}`
+)
+
+func TestPoolLogAcrossVMAFOSSExecVersions(t *testing.T) {
+	for name, log := range map[string]string{"1.3": vmafossexec13Log, "1.5": vmafossexec15Log} {
+		path := filepath.Join(t.TempDir(), "vmaf.log")
+		if err := ioutil.WriteFile(path, []byte(log), 0644); err != nil {
+			t.Fatal(err)
+		}
+		v := NewVMAFEstimator("", "", "vmaf_v0.6.1.pkl", t.TempDir(), 1, 1)
+		v.PoolMethod = PoolMean
+		result, err := v.poolLog(path, nil)
+		if err != nil {
+			t.Fatalf("vmafossexec %s: %v", name, err)
+		}
+		if math.Abs(result.VMAF-85) > 1e-9 || math.Abs(result.PSNR-38) > 1e-9 || math.Abs(result.SSIM-0.96) > 1e-9 || math.Abs(result.MSSSIM-0.95) > 1e-9 {
+			t.Errorf("vmafossexec %s: pooled %+v, want VMAF 85, PSNR 38, SSIM 0.96 and MS-SSIM 0.95", name, result)
+		}
+	}
+}
+
+func TestPoolLogWithoutVMAFFails(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vmaf.log")
+	if err := ioutil.WriteFile(path, []byte(`{"frames":[{"frameNum":0,"metrics":{"adm2":0.98,"VMAF_v2_score":90}}]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	v := NewVMAFEstimator("", "", "vmaf_v0.6.1.pkl", t.TempDir(), 1, 1)
+	if _, err := v.poolLog(path, nil); err == nil || !strings.Contains(err.Error(), "no VMAF score") {
+		t.Errorf("error %v, want the unknown VMAF name reported", err)
+	}
+}
+
+func TestVMAFEstimatorPreflight(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	good := write("vmaf_v0.6.1.pkl", "(dp0\n")
+	write("vmaf_v0.6.1.pkl.model", "model\n")
+	lonely := write("lonely.pkl", "(dp0\n")
+	notPickle := write("text.pkl", "not a pickle\n")
+	write("text.pkl.model", "model\n")
+
+	tests := []struct {
+		name  string
+		model string
+		// vmafossexec for the model, failing unless ok
+		ok   bool
+		want string
+	}{
+		{"missing file", filepath.Join(dir, "missing.pkl"), true, "Failed to read VMAF model"},
+		{"wrong format", notPickle, true, "isn't a pickled model"},
+		{"missing companion", lonely, true, "missing its companion"},
+		{"rejected by vmafossexec", good, false, "VMAF failed to load model"},
+		{"loads", good, true, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			script := "echo 'could not load model' >&2\nexit 1\n"
+			if test.ok {
+				script = recordArgs
+			}
+			t.Setenv("ARGS_FILE", filepath.Join(t.TempDir(), "args"))
+			bin := fakeBinary(t, "vmafossexec", script)
+			t.Setenv("PATH", filepath.Dir(bin)+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+			err := NewVMAFEstimator("", "", test.model, t.TempDir(), 1, 1).Preflight(context.Background())
+			switch {
+			case test.want == "" && err != nil:
+				t.Errorf("Preflight() = %v, want the model to load", err)
+			case test.want != "" && (err == nil || !strings.Contains(err.Error(), test.want)):
+				t.Errorf("Preflight() = %v, want an error containing %q", err, test.want)
+			}
+		})
+	}
+}
+
+func TestPoolFrames(t *testing.T) {
+	frames := func(scores ...float64) []*VMAFFrame {
+		var out []*VMAFFrame
+		for i, score := range scores {
+			out = append(out, &VMAFFrame{FrameNum: i, Metrics: &VMAFMetrics{VMAF: score, HasVMAF: true}})
+		}
+		return out
+	}
+	tests := []struct {
+		name   string
+		frames []*VMAFFrame
+		method PoolMethod
+		policy ZeroPolicy
+		want   float64
+	}{
+		{"empty", nil, PoolHarmonicMean, ZeroPolicyNone, 0},
+		{"single frame", frames(87.5), PoolHarmonicMean, ZeroPolicyNone, 87.5},
+		{"harmonic mean", frames(60, 90), PoolHarmonicMean, ZeroPolicyNone, 72},
+		{"zero score", frames(0, 90), PoolHarmonicMean, ZeroPolicyNone, 0},
+		{"zero score mean", frames(0, 90), PoolMean, ZeroPolicyNone, 45},
+		{"zero score dropped", frames(0, 90), PoolHarmonicMean, ZeroPolicyDrop, 90},
+		{"min", frames(60, 90, 75), PoolMin, ZeroPolicyNone, 60},
+		{"max", frames(60, 90, 75), PoolMax, ZeroPolicyNone, 90},
+		{"frame without metrics", append(frames(90), &VMAFFrame{FrameNum: 1}), PoolMean, ZeroPolicyNone, 45},
+	}
+	for _, test := range tests {
+		got, err := poolFrames(test.frames, test.method, test.policy)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if math.Abs(got-test.want) > 1e-9 {
+			t.Errorf("%s: poolFrames = %f, want %f", test.name, got, test.want)
+		}
+	}
+	if _, err := poolFrames(frames(0, 90), PoolHarmonicMean, ZeroPolicyFail); err == nil {
+		t.Errorf("poolFrames pooled a zero score with the fail zero policy")
+	}
+}
+
+func TestExtractMetric(t *testing.T) {
+	frames := []*VMAFFrame{
+		{Metrics: &VMAFMetrics{VMAF: 90, Psnr: 40, Ssim: 0.97}},
+		{Metrics: &VMAFMetrics{VMAF: 80, PsnrY: 38, FloatSsim: 0.95}},
+		{},
+	}
+	for name, want := range map[string][]float64{
+		"vmaf": {90, 80, 0},
+		"psnr": {40, 38, 0},
+		"ssim": {0.97, 0.95, 0},
+	} {
+		got := extractMetric(frames, name)
+		if len(got) != len(want) {
+			t.Fatalf("%s: got %v, want %v", name, got, want)
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("%s: got %v, want %v", name, got, want)
+				break
+			}
+		}
+	}
+	if got := extractMetric(frames, "vif"); got != nil {
+		t.Errorf("unknown metric gave %v, want nil", got)
+	}
+	if got := extractMetric(nil, "vmaf"); len(got) != 0 {
+		t.Errorf("no frames gave %v, want none", got)
+	}
+}
+
+func TestPoolLogRejectsEmptyAndZeroFrames(t *testing.T) {
+	for name, log := range map[string]string{
+		"empty frames": `{"frames":[]}`,
+		"all zero":     `{"frames":[{"frameNum":0,"metrics":{"vmaf":0}},{"frameNum":1,"metrics":{"vmaf":0}}]}`,
+	} {
+		path := filepath.Join(t.TempDir(), "vmaf.log")
+		if err := ioutil.WriteFile(path, []byte(log), 0644); err != nil {
+			t.Fatal(err)
+		}
+		v := NewVMAFEstimator("", "", "vmaf_v0.6.1.pkl", t.TempDir(), 1, 1)
+		result, err := v.poolLog(path, nil)
+		if err == nil {
+			t.Errorf("%s: pooled to %+v, want an error rather than NaN or 0", name, result)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// libvmafFeatures are the libvmaf features computing the metrics, vmafossexec's --psnr --ssim --ms-ssim
+func (e ExtraMetrics) libvmafFeatures() []string {
+	var features []string
+	if e.PSNR {
+		features = append(features, "psnr")
+	}
+	if e.SSIM {
+		features = append(features, "float_ssim")
+	}
+	if e.MSSSIM {
+		features = append(features, "float_ms_ssim")
+	}
+	return features
+}
+
+// LibVMAFEstimator runs libvmaf's vmaf tool, which replaces the deprecated vmafossexec.
+// Logs, pooling and model profiles are shared with the vmafossexec estimator.
+type LibVMAFEstimator struct {
+	*VMAFEstimator
+	// Features are the metrics computed alongside VMAF
+	Features []string
+}
+
+func NewLibVMAFEstimator(cpu *VMAFEstimator) *LibVMAFEstimator {
+	return &LibVMAFEstimator{VMAFEstimator: cpu, Features: cpu.Extras.libvmafFeatures()}
+}
+
+// Preflight checks the vmaf tool is installed
+func (v *LibVMAFEstimator) Preflight(ctx context.Context) error {
+	if _, err := exec.LookPath("vmaf"); err != nil {
+		return fmt.Errorf("libvmaf's vmaf tool is needed for the %s estimator: %v", EstimatorLibVMAF, err)
+	}
+	// shipped .pkl models are built into libvmaf, only JSON models are loaded from disk
+	if v.scoresVMAF() && filepath.Ext(v.ModelPath) == ".json" {
+		return validateModelFile(v.ModelPath)
+	}
+	return nil
+}
+
+// modelArg maps the model onto a libvmaf --model argument, libvmaf loads JSON models from a
+// path and has the shipped pkl models built in by version. The phone transform is a model option.
+func (v *LibVMAFEstimator) modelArg() string {
+	arg := "version=" + strings.TrimSuffix(filepath.Base(v.ModelPath), filepath.Ext(v.ModelPath))
+	if filepath.Ext(v.ModelPath) == ".json" {
+		arg = "path=" + v.ModelPath
+	}
+	if v.PhoneModel {
+		arg += ":enable_transform=true"
+	}
+	return arg
+}
+
+// CalculateVMAF ...
+func (v *LibVMAFEstimator) CalculateVMAF(ctx context.Context, variant, width, height uint64) (*VMAFResult, error) {
+	if width < v.Profile.MinResolution || height < v.Profile.MinResolution {
+		return nil, fmt.Errorf("%dx%d is below the %s model's minimum resolution of %d", width, height, v.Profile.Name, v.Profile.MinResolution)
+	}
+
+	logsFile := v.LogPath(variant, width, height)
+	args := []string{
+		"--reference", v.ReferencesDecodePath,
+		"--distorted", v.DistortedDecodePath,
+		"--width", fmt.Sprintf("%d", width),
+		"--height", fmt.Sprintf("%d", height),
+		"--pixel_format", v.PixelFormat.Chroma,
+		"--bitdepth", fmt.Sprintf("%d", v.PixelFormat.BitDepth),
+		"--threads", fmt.Sprintf("%d", v.Threads),
+		"--pool", string(v.PoolMethod),
+	}
+	if v.Subsample > 1 {
+		args = append(args, "--subsample", fmt.Sprintf("%d", v.Subsample))
+	}
+	features := v.Features
+	if v.scoresVMAF() {
+		args = append(args, "--model", v.modelArg())
+	} else {
+		// skip loading the model and extracting its features, computing just the one metric
+		args = append(args, "--no_prediction")
+		features = []string{v.Metric.libvmafFeature()}
+	}
+	for _, feature := range features {
+		args = append(args, "--feature", feature)
+	}
+	args = append(args, "--json", "--output", logsFile)
+
+	stdoutData, err := runCommand(exec.CommandContext(ctx, "vmaf", args...), v.Limits)
+	if err != nil {
+		slog.Debug("VMAF output", "output", string(stdoutData))
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return nil, fmt.Errorf("Error running libvmaf: %s", exitErr.Stderr)
+		}
+		return nil, fmt.Errorf("Unexpected error running libvmaf: %v", err)
+	}
+	return v.poolLog(logsFile, stdoutData)
+}
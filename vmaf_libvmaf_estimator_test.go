@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeVMAFTool puts a vmaf on PATH recording its arguments and copying fixture to its --output
+func fakeVMAFTool(t *testing.T, fixture string) string {
+	t.Helper()
+	argsFile := filepath.Join(t.TempDir(), "args")
+	t.Setenv("ARGS_FILE", argsFile)
+	vmaf := fakeBinary(t, "vmaf", recordArgs+`while [ $# -gt 0 ]; do [ "$1" = "--output" ] && cp `+fixture+` "$2"; shift; done`+"\n")
+	t.Setenv("PATH", filepath.Dir(vmaf)+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return argsFile
+}
+
+func TestLibVMAFEstimatorArgs(t *testing.T) {
+	fixture := filepath.Join(t.TempDir(), "fixture.json")
+	writeVMAFLog(t, fixture, 90, 90)
+	argsFile := fakeVMAFTool(t, fixture)
+
+	cpu := NewVMAFEstimator("/work/mezzanine.yuv", "/work/distorted.yuv", "vmaf_v0.6.1.pkl", t.TempDir(), 2, 1)
+	cpu.Extras = ExtraMetrics{PSNR: true}
+	result, err := NewLibVMAFEstimator(cpu).CalculateVMAF(context.Background(), 1, 1280, 720)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.VMAF < 89.99 || result.VMAF > 90.01 {
+		t.Errorf("VMAF = %f, want 90", result.VMAF)
+	}
+
+	args := recordedArgs(t, argsFile)
+	want := map[string]string{
+		"--reference":    "/work/mezzanine.yuv",
+		"--distorted":    "/work/distorted.yuv",
+		"--width":        "1280",
+		"--height":       "720",
+		"--pixel_format": "420",
+		"--bitdepth":     "8",
+		"--model":        "version=vmaf_v0.6.1",
+		"--feature":      "psnr",
+		"--output":       cpu.LogPath(1, 1280, 720),
+	}
+	for flag, value := range want {
+		if got := argAfter(args, flag); got != value {
+			t.Errorf("%s = %q, want %q", flag, got, value)
+		}
+	}
+	if strings.Contains(strings.Join(args, " "), "float_ssim") {
+		t.Errorf("args %v compute SSIM, which wasn't asked for", args)
+	}
+}
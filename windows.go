@@ -0,0 +1,100 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+// frameWindow is a [start, end) range of frames scored on its own, for content too long to sweep in
+// a single decode
+type frameWindow struct {
+	start, end uint64
+}
+
+// keyframeIndexes converts keyframe times into frame indexes at fps, in order and without repeats
+func keyframeIndexes(times []float64, fps float64) []uint64 {
+	var indexes []uint64
+	for _, t := range times {
+		if t < 0 {
+			continue
+		}
+		indexes = append(indexes, uint64(math.Round(t*fps)))
+	}
+	sort.Slice(indexes, func(i, j int) bool { return indexes[i] < indexes[j] })
+	var unique []uint64
+	for i, index := range indexes {
+		if i == 0 || index != indexes[i-1] {
+			unique = append(unique, index)
+		}
+	}
+	return unique
+}
+
+// frameWindows splits frames [first, last) into windows of at least length frames, each but the first
+// starting on the first keyframe at or after the previous window's length is up. The last window
+// runs to last however short it is. Without any keyframes the windows are exactly length frames.
+func frameWindows(keyframes []uint64, length, first, last uint64) []frameWindow {
+	if length == 0 || first >= last {
+		return []frameWindow{{first, last}}
+	}
+	var windows []frameWindow
+	start := first
+	k := 0
+	for start < last {
+		end := last
+		if len(keyframes) == 0 && start+length < last {
+			end = start + length
+		}
+		for ; k < len(keyframes); k++ {
+			if keyframes[k] >= start+length {
+				break
+			}
+		}
+		if k < len(keyframes) && keyframes[k] < last {
+			end = keyframes[k]
+		}
+		windows = append(windows, frameWindow{start, end})
+		start = end
+	}
+	return windows
+}
+
+// combineWindowResults pools the results of scoring each window into the result scoring all of them at
+// once would have given, weighting each window by its frames. Pooled VMAF combines exactly for every
+// pool method, the harmonic mean of the frames being the weighted harmonic mean of the windows'.
+func combineWindowResults(results []*VMAFResult, windows []frameWindow, method PoolMethod) *VMAFResult {
+	combined := *results[0]
+	var total, psnr, ssim, msSsim, mean, inverse float64
+	lowest, highest := math.Inf(1), math.Inf(-1)
+	zero := false
+	for i, result := range results {
+		frames := float64(windows[i].end - windows[i].start)
+		total += frames
+		psnr += frames * result.PSNR
+		ssim += frames * result.SSIM
+		msSsim += frames * result.MSSSIM
+		mean += frames * result.VMAF
+		if result.VMAF == 0 {
+			zero = true
+		} else {
+			inverse += frames / result.VMAF
+		}
+		lowest, highest = math.Min(lowest, result.VMAF), math.Max(highest, result.VMAF)
+		combined.Spread.Min = math.Min(combined.Spread.Min, result.Spread.Min)
+	}
+	combined.PSNR, combined.SSIM, combined.MSSSIM = psnr/total, ssim/total, msSsim/total
+	switch method {
+	case PoolMean:
+		combined.VMAF = mean / total
+	case PoolMin:
+		combined.VMAF = lowest
+	case PoolMax:
+		combined.VMAF = highest
+	default:
+		combined.VMAF = 0
+		if !zero {
+			combined.VMAF = total / inverse
+		}
+	}
+	return &combined
+}